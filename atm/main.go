@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/bukharney/bank-core/atm/models"
 	"github.com/bukharney/bank-core/atm/session"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // dispenseCash simulates dispensing cash.
@@ -19,7 +27,9 @@ func dispenseCash(w http.ResponseWriter, r *http.Request, s session.SessionM) {
 		return
 	}
 
-	log.Printf("Received dispense request: SessionID=%s, Amount=%d", req.SessionID, req.Amount)
+	// The trace ID links this log line to the withdrawal in the core API
+	traceID := trace.SpanContextFromContext(r.Context()).TraceID()
+	log.Printf("Received dispense request: SessionID=%s, Amount=%d, TraceID=%s", req.SessionID, req.Amount, traceID)
 
 	if req.SessionID == "" || req.Amount <= 0 {
 		http.Error(w, "Invalid session ID or amount", http.StatusBadRequest)
@@ -81,13 +91,46 @@ func spawnATMServer(n int) {
 				w.WriteHeader(http.StatusOK)
 				w.Write([]byte(fmt.Sprintf(`{"session_id": "%s"}`, s.CreateSession(5*time.Minute))))
 			}))
-			log.Printf("ATM server started on :808%d", i)
-			log.Fatal(http.ListenAndServe(fmt.Sprintf(":808%d", i+1), mux))
+			log.Printf("ATM server started on :808%d", i+1)
+			// Continue the trace of the core API from the traceparent header
+			handler := otelhttp.NewHandler(mux, "atm", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return r.Method + " " + r.URL.Path
+			}))
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":808%d", i+1), handler))
 		}()
 	}
 }
 
+// tracingConfig reads the exporter settings from the same BANK_TRACING_*
+// variables as the core API, reporting as the atm service
+func tracingConfig() config.Tracing {
+	cfg := config.NewConfig().Tracing
+	cfg.ServiceName = "atm"
+	if exporter := os.Getenv("BANK_TRACING_EXPORTER"); exporter != "" {
+		cfg.Exporter = exporter
+	}
+	if endpoint := os.Getenv("BANK_TRACING_ENDPOINT"); endpoint != "" {
+		cfg.Endpoint = endpoint
+	}
+
+	return cfg
+}
+
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, tracingConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	spawnATMServer(3)
-	select {}
+	<-ctx.Done()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("Could not flush traces: %v", err)
+	}
 }
@@ -1,35 +1,158 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/bukharney/bank-core/internal/api/middleware"
+	"github.com/bukharney/bank-core/internal/api/repositories"
 	"github.com/bukharney/bank-core/internal/api/routes"
 	"github.com/bukharney/bank-core/internal/config"
 	"github.com/bukharney/bank-core/internal/db"
+	"github.com/bukharney/bank-core/internal/keys"
 	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/tracing"
+	"github.com/bukharney/bank-core/internal/utils"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the server and returns the process exit code:
+// 0 after a clean shutdown, 1 if startup or shutdown failed
+func run() int {
+	configPath := flag.String("config", os.Getenv("BANK_CONFIG"), "path to the YAML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
 	logger.InitLogger()
 	defer logger.CloseLogger()
+
+	config, err := config.Load(*configPath)
+	if err != nil {
+		logger.Logger.Errorf("Invalid configuration: %v", err)
+		return 1
+	}
+
+	if *printConfig {
+		out, err := config.Redacted().YAML()
+		if err != nil {
+			logger.Logger.Error(err)
+			return 1
+		}
+		fmt.Print(string(out))
+		return 0
+	}
+
+	keySet, err := keys.Load(config.JWT)
+	if err != nil {
+		logger.Logger.Errorf("Could not load JWT keys: %v", err)
+		return 1
+	}
+	if config.JWT.KeysDir == "" {
+		logger.Logger.Warn("No jwt.keys_dir configured, signing tokens with a generated key that is lost on restart")
+	}
+	utils.SetKeySet(keySet)
+
+	// SIGINT/SIGTERM cancel ctx, which aborts connection retries at startup
+	// and starts the graceful shutdown once the server is running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, config.Tracing)
+	if err != nil {
+		logger.Logger.Errorf("Could not set up tracing: %v", err)
+		return 1
+	}
+	defer func() {
+		// Flush the spans of the last requests before exiting
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Logger.Errorf("Could not flush traces: %v", err)
+		}
+	}()
+
+	pg, err := db.ConnectWithRetry(ctx, config)
+	if err != nil {
+		logger.Logger.Errorf("Could not connect to postgres: %v", err)
+		return 1
+	}
+	defer pg.Close()
+
+	rdb, err := db.RedisConnectWithRetry(ctx, config)
+	if err != nil {
+		logger.Logger.Errorf("Could not connect to redis: %v", err)
+		return 1
+	}
+	defer rdb.Close()
+
 	mux := http.NewServeMux()
+	inFlight := middleware.NewInFlight()
+	authRepo := repositories.NewAuthRepository(pg, rdb, config)
+	serv := middleware.ApplyMiddleware(config, authRepo, routes.Timeouts(config), inFlight.Middleware(mux))
+	err = routes.MapHandler(config, mux, pg, rdb, keySet)
+	if err != nil {
+		logger.Logger.Errorf("Could not map routes: %v", err)
+		return 1
+	}
+
+	server := &http.Server{
+		Addr:              config.Server.Addr,
+		Handler:           serv,
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		ReadTimeout:       config.Server.ReadTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Logger.Infof("Server is running on %s", config.Server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
 
-	config := config.NewConfig()
+	select {
+	case err := <-serverErr:
+		logger.Logger.Errorf("Server stopped: %v", err)
+		return 1
+	case <-ctx.Done():
+	}
+
+	stop()
+	logger.Logger.Info("Shutting down, draining in-flight requests")
 
-	pg, err := db.Connect(config)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+
+	code := 0
+	err = server.Shutdown(shutdownCtx)
 	if err != nil {
-		panic(err)
+		logger.Logger.Errorf("Could not shut down the server cleanly: %v", err)
+		code = 1
 	}
 
-	rdb, err := db.RedisConnect(config)
+	// Handlers abandoned by the request timeout, and emails they sent in
+	// the background, may still be running
+	err = inFlight.Wait(shutdownCtx)
 	if err != nil {
-		panic(err)
+		logger.Logger.Errorf("Gave up waiting for in-flight requests: %v", err)
+		code = 1
 	}
 
-	serv := middleware.ApplyMiddleware(mux)
-	routes.MapHandler(config, mux, pg, rdb)
+	if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Logger.Errorf("Server stopped: %v", err)
+		code = 1
+	}
 
-	logger.Logger.Info("Server is running on port 8080")
-	http.ListenAndServe(":8080", serv)
+	logger.Logger.Info("Server stopped")
+	return code
 }
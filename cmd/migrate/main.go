@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/db"
+	logger "github.com/bukharney/bank-core/internal/logs"
+)
+
+const usage = `Usage: migrate [flags] <command>
+
+Commands:
+  up           apply all pending migrations
+  down N       revert the N most recent migrations (default 1)
+  status       list migrations and when they were applied
+  create NAME  create an empty up/down migration pair in -dir
+`
+
+func main() {
+	configPath := flag.String("config", os.Getenv("BANK_CONFIG"), "path to the YAML config file")
+	dir := flag.String("dir", "./internal/db/migrations", "migrations directory used by create")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logger.InitLogger()
+	defer logger.CloseLogger()
+
+	if err := run(*configPath, *dir, flag.Args()); err != nil {
+		logger.Logger.Error(err)
+		logger.CloseLogger()
+		os.Exit(1)
+	}
+}
+
+func run(configPath string, dir string, args []string) error {
+	// create only touches the source tree and does not need a database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return fmt.Errorf("usage: migrate create NAME")
+		}
+
+		paths, err := db.CreateMigration(dir, args[1])
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		return nil
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	pg, err := db.ConnectWithRetry(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer pg.Close()
+
+	migrator, err := db.NewMigrator(pg)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s)\n", len(applied))
+
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d migration(s)\n", len(reverted))
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-40s %s\n", status.Version, status.Name, applied)
+		}
+
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return nil
+}
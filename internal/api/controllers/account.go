@@ -21,7 +21,7 @@ type AccountController struct {
 func NewAccountController(cfg *config.Config, usecase models.AccountUsecase) *AccountController {
 	return &AccountController{
 		Cfg:      cfg,
-		Validate: validator.New(),
+		Validate: utils.NewValidator(),
 		Usecase:  usecase,
 	}
 }
@@ -30,13 +30,13 @@ func NewAccountController(cfg *config.Config, usecase models.AccountUsecase) *Ac
 func (c *AccountController) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
 	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
 	if err != nil {
-		responses.Unauthorized(w, err)
+		responses.Unauthorized(w, r, err)
 		return
 	}
 
-	err = c.Usecase.CreateAccount(userId)
+	err = c.Usecase.CreateAccount(r.Context(), userId)
 	if err != nil {
-		responses.Error(w, http.StatusInternalServerError, err)
+		responses.Error(w, r, err)
 		return
 	}
 
@@ -47,13 +47,13 @@ func (c *AccountController) CreateAccountHandler(w http.ResponseWriter, r *http.
 func (c *AccountController) GetAccountHandler(w http.ResponseWriter, r *http.Request) {
 	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
 	if err != nil {
-		responses.Unauthorized(w, err)
+		responses.Unauthorized(w, r, err)
 		return
 	}
 
-	account, err := c.Usecase.GetAccountsByUserID(userId)
+	account, err := c.Usecase.GetAccountsByUserID(r.Context(), userId)
 	if err != nil {
-		responses.Error(w, http.StatusInternalServerError, err)
+		responses.Error(w, r, err)
 		return
 	}
 
@@ -64,13 +64,13 @@ func (c *AccountController) GetAccountHandler(w http.ResponseWriter, r *http.Req
 func (c *AccountController) GetAccountByIDHandler(w http.ResponseWriter, r *http.Request) {
 	accountId, err := utils.GetIDFromRequest(r, "id")
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
 		return
 	}
 
-	account, err := c.Usecase.GetAccountByID(accountId)
+	account, err := c.Usecase.GetAccountByID(r.Context(), accountId)
 	if err != nil {
-		responses.Error(w, http.StatusInternalServerError, err)
+		responses.Error(w, r, err)
 		return
 	}
 
@@ -23,7 +23,7 @@ func NewAuthController(cfg *config.Config, usecase models.AuthUsecase) *AuthCont
 	return &AuthController{
 		Cfg:      cfg,
 		Usecase:  usecase,
-		Validate: validator.New(),
+		Validate: utils.NewValidator(),
 	}
 }
 
@@ -32,22 +32,66 @@ func (c *AuthController) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	login := &models.UserCredentials{}
 	err := utils.DecodeJSON(r, login)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
+		return
 	}
 
 	err = c.Validate.Struct(login)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
 		return
 	}
 
-	token, err := c.Usecase.Login(login)
+	token, err := c.Usecase.Login(r.Context(), login, utils.GetClientInfo(r))
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Error(w, r, err)
+		return
+	}
+
+	// No session is started until the MFA code is checked
+	if token.MFARequired {
+		responses.Success(w, token)
 		return
 	}
 
-	utils.SetToken(w, token, time.Now().Add(24*time.Hour))
+	token.CSRFToken, err = utils.NewCSRFToken()
+	if err != nil {
+		responses.InternalServerError(w, r, err)
+		return
+	}
+
+	utils.SetToken(w, token, time.Now().Add(c.Cfg.JWT.RefreshTTL))
+	responses.Success(w, token)
+}
+
+// LoginMFAHandler handles the second step of a login with MFA
+func (c *AuthController) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	login := &models.MFALoginRequest{}
+	err := utils.DecodeJSON(r, login)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Validate.Struct(login)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	token, err := c.Usecase.LoginMFA(r.Context(), login, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	token.CSRFToken, err = utils.NewCSRFToken()
+	if err != nil {
+		responses.InternalServerError(w, r, err)
+		return
+	}
+
+	utils.SetToken(w, token, time.Now().Add(c.Cfg.JWT.RefreshTTL))
 	responses.Success(w, token)
 }
 
@@ -55,17 +99,23 @@ func (c *AuthController) LoginHandler(w http.ResponseWriter, r *http.Request) {
 func (c *AuthController) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := utils.ExtractToken(r, "refresh_token")
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	token, err := c.Usecase.RefreshToken(r.Context(), refreshToken, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
 		return
 	}
 
-	token, err := c.Usecase.RefreshToken(refreshToken)
+	token.CSRFToken, err = utils.NewCSRFToken()
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.InternalServerError(w, r, err)
 		return
 	}
 
-	utils.SetToken(w, token, time.Now().Add(24*time.Hour))
+	utils.SetToken(w, token, time.Now().Add(c.Cfg.JWT.RefreshTTL))
 	responses.Success(w, token)
 }
 
@@ -73,13 +123,13 @@ func (c *AuthController) RefreshTokenHandler(w http.ResponseWriter, r *http.Requ
 func (c *AuthController) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := utils.ExtractToken(r, "refresh_token")
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Unauthorized(w, r, err)
 		return
 	}
 
-	err = c.Usecase.Logout(refreshToken)
+	err = c.Usecase.Logout(r.Context(), refreshToken)
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Error(w, r, err)
 		return
 	}
 
@@ -93,20 +143,233 @@ func (c *AuthController) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 func (c *AuthController) MeHandler(w http.ResponseWriter, r *http.Request) {
 	accessToken, err := utils.ExtractToken(r, "access_token")
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Unauthorized(w, r, err)
 		return
 	}
 
-	user, err := c.Usecase.Me(accessToken)
+	user, err := c.Usecase.Me(r.Context(), accessToken)
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Error(w, r, err)
 		return
 	}
 
 	responses.Success(w, user)
 }
 
+// ListSessionsHandler handles the list sessions route
+func (c *AuthController) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetTokenClaimsFromRequest(c.Cfg, r)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	sessions, err := c.Usecase.ListSessions(r.Context(), claims.UserID, claims.SessionID)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, sessions)
+}
+
+// RevokeSessionHandler handles the revoke session route
+func (c *AuthController) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetTokenClaimsFromRequest(c.Cfg, r)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	sessionId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Usecase.RevokeSession(r.Context(), claims.UserID, sessionId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	// Revoking the current session logs the caller out
+	if sessionId == claims.SessionID {
+		utils.SetToken(w, &models.LoginResponse{}, time.Now())
+	}
+
+	responses.NoContent(w)
+}
+
+// LogoutAllHandler handles the log out everywhere route
+func (c *AuthController) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	err = c.Usecase.LogoutAll(r.Context(), userId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	utils.SetToken(w, &models.LoginResponse{}, time.Now())
+	responses.NoContent(w)
+}
+
+// ListUserSessionsHandler handles the admin list user sessions route
+func (c *AuthController) ListUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	sessions, err := c.Usecase.ListUserSessions(r.Context(), userId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, sessions)
+}
+
+// RevokeUserSessionsHandler handles the admin revoke user sessions route
+func (c *AuthController) RevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	adminId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	userId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Usecase.RevokeUserSessions(r.Context(), adminId, userId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.NoContent(w)
+}
+
+// AssignRoleHandler handles the admin assign role route
+func (c *AuthController) AssignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	adminId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	userId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	req := &models.AssignRoleRequest{}
+	err = utils.DecodeJSON(r, req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Validate.Struct(req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Usecase.AssignRole(r.Context(), adminId, userId, req.Role)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.NoContent(w)
+}
+
 // TestHandler handles the test route
 func (c *AuthController) TestHandler(w http.ResponseWriter, r *http.Request) {
 	responses.Success(w, "Hello, World!")
 }
+
+// UnlockUserHandler handles the admin unlock user route
+func (c *AuthController) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	adminId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	userId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Usecase.UnlockUser(r.Context(), adminId, userId, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.NoContent(w)
+}
+
+// ForgotPasswordHandler handles the forgot password route
+func (c *AuthController) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	req := &models.ForgotPasswordRequest{}
+	err := utils.DecodeJSON(r, req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Validate.Struct(req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Usecase.ForgotPassword(r.Context(), req.Email)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	// The same answer whether or not the email has an account
+	responses.Message(w, http.StatusAccepted, "if an account uses this email, a password reset link has been sent to it")
+}
+
+// ResetPasswordHandler handles the reset password route
+func (c *AuthController) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	req := &models.ResetPasswordRequest{}
+	err := utils.DecodeJSON(r, req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Validate.Struct(req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Usecase.ResetPassword(r.Context(), req, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	// Every session was revoked, including any of this browser
+	utils.SetToken(w, &models.LoginResponse{}, time.Now())
+	responses.NoContent(w)
+}
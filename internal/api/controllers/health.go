@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/health"
+	"github.com/bukharney/bank-core/internal/responses"
+)
+
+// HealthController is the controller for the liveness and readiness routes
+type HealthController struct {
+	Cfg     *config.Config
+	Checker *health.Checker
+}
+
+// NewHealthController creates a new HealthController
+func NewHealthController(cfg *config.Config, checker *health.Checker) *HealthController {
+	return &HealthController{
+		Cfg:     cfg,
+		Checker: checker,
+	}
+}
+
+// LivenessHandler handles the liveness route.
+// It does not touch any dependency, the process answering is enough.
+func (c *HealthController) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	responses.Success(w, map[string]string{"status": health.StatusUp})
+}
+
+// ReadinessHandler handles the readiness route
+func (c *HealthController) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := c.Checker.Run(r.Context())
+	if !report.Ready() {
+		responses.JSON(w, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	responses.Success(w, report)
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/keys"
+	"github.com/bukharney/bank-core/internal/responses"
+)
+
+// KeysController is the controller for the JWKS route
+type KeysController struct {
+	Cfg  *config.Config
+	Keys *keys.KeySet
+}
+
+// NewKeysController creates a new KeysController
+func NewKeysController(cfg *config.Config, keySet *keys.KeySet) *KeysController {
+	return &KeysController{
+		Cfg:  cfg,
+		Keys: keySet,
+	}
+}
+
+// JWKSHandler handles the JWKS route.
+// Other services fetch it to verify our tokens without sharing a secret.
+func (c *KeysController) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	// Let verifiers cache the keys, rotation leaves time for them to refresh
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	responses.Success(w, c.Keys.JWKS())
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/responses"
+	"github.com/bukharney/bank-core/internal/utils"
+	"github.com/go-playground/validator/v10"
+)
+
+// multipartOverhead is the room left for the multipart headers and the type
+// field of a document upload on top of the document itself
+const multipartOverhead = 64 << 10
+
+// KYCController is the controller for the identity verification routes
+type KYCController struct {
+	Cfg      *config.Config
+	Validate *validator.Validate
+	Usecase  models.KYCUsecase
+}
+
+// NewKYCController creates a new KYCController
+func NewKYCController(cfg *config.Config, usecase models.KYCUsecase) *KYCController {
+	return &KYCController{
+		Cfg:      cfg,
+		Validate: utils.NewValidator(),
+		Usecase:  usecase,
+	}
+}
+
+// GetKYCHandler handles the route of the user's own verification
+func (c *KYCController) GetKYCHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	kyc, err := c.Usecase.GetKYC(r.Context(), userId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, kyc)
+}
+
+// UploadDocumentHandler handles the document upload route. The document is
+// sent as multipart form data, with its type in the type field and the
+// document in the file field.
+func (c *KYCController) UploadDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	maxSize := c.Cfg.KYC.MaxDocumentSize
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	err = r.ParseMultipartForm(maxSize)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			responses.Error(w, r, models.ErrDocumentTooLarge)
+			return
+		}
+		responses.BadRequest(w, r, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	document, err := c.Usecase.UploadDocument(r.Context(), userId, r.FormValue("type"), file)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Created(w, document)
+}
+
+// SubmitHandler handles the route that submits the user's documents for review
+func (c *KYCController) SubmitHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	kyc, err := c.Usecase.Submit(r.Context(), userId, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, kyc)
+}
+
+// ListReviewsHandler handles the compliance route listing verifications, by
+// the status query parameter
+func (c *KYCController) ListReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	kycs, err := c.Usecase.ListReviews(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, kycs)
+}
+
+// GetUserKYCHandler handles the compliance route of a user's verification
+func (c *KYCController) GetUserKYCHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	kyc, err := c.Usecase.GetKYC(r.Context(), userId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, kyc)
+}
+
+// GetDocumentHandler handles the compliance route that downloads a document
+func (c *KYCController) GetDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	documentId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	document, file, err := c.Usecase.OpenDocument(r.Context(), documentId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	// Documents are downloaded rather than rendered, and never cached
+	w.Header().Set("Content-Type", document.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(document.Size, 10))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+document.ID.String()+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+
+	io.Copy(w, file)
+}
+
+// ApproveHandler handles the compliance route that approves a verification
+func (c *KYCController) ApproveHandler(w http.ResponseWriter, r *http.Request) {
+	reviewerId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	userId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	kyc, err := c.Usecase.Approve(r.Context(), reviewerId, userId, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, kyc)
+}
+
+// RejectHandler handles the compliance route that rejects a verification
+func (c *KYCController) RejectHandler(w http.ResponseWriter, r *http.Request) {
+	reviewerId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	userId, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	req := &models.RejectKYCRequest{}
+	err = utils.DecodeJSON(r, req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Validate.Struct(req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	kyc, err := c.Usecase.Reject(r.Context(), reviewerId, userId, req.Reason, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, kyc)
+}
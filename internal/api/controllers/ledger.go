@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/responses"
+	"github.com/bukharney/bank-core/internal/utils"
+)
+
+// LedgerController is the controller for the ledger routes
+type LedgerController struct {
+	Cfg     *config.Config
+	Usecase models.LedgerUsecase
+}
+
+// NewLedgerController creates a new LedgerController
+func NewLedgerController(cfg *config.Config, usecase models.LedgerUsecase) *LedgerController {
+	return &LedgerController{
+		Cfg:     cfg,
+		Usecase: usecase,
+	}
+}
+
+// TrialBalanceHandler handles the trial balance route
+func (c *LedgerController) TrialBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	trialBalance, err := c.Usecase.GetTrialBalance(r.Context(), userId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, trialBalance)
+}
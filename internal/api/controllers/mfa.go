@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/responses"
+	"github.com/bukharney/bank-core/internal/utils"
+	"github.com/go-playground/validator/v10"
+)
+
+// MFAController is the controller for the MFA routes
+type MFAController struct {
+	Cfg      *config.Config
+	Usecase  models.MFAUsecase
+	Validate *validator.Validate
+}
+
+// NewMFAController creates a new MFAController
+func NewMFAController(cfg *config.Config, usecase models.MFAUsecase) *MFAController {
+	return &MFAController{
+		Cfg:      cfg,
+		Usecase:  usecase,
+		Validate: utils.NewValidator(),
+	}
+}
+
+// EnrollHandler handles the MFA enroll route
+func (c *MFAController) EnrollHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	enrollment, err := c.Usecase.Enroll(r.Context(), userId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	// The secret must not be kept by caches or the browser history
+	w.Header().Set("Cache-Control", "no-store")
+	responses.Success(w, enrollment)
+}
+
+// ConfirmHandler handles the MFA confirm route
+func (c *MFAController) ConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	userId, req, ok := c.decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := c.Usecase.Confirm(r.Context(), userId, req.Code)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	responses.Success(w, codes)
+}
+
+// DisableHandler handles the MFA disable route
+func (c *MFAController) DisableHandler(w http.ResponseWriter, r *http.Request) {
+	userId, req, ok := c.decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	err := c.Usecase.Disable(r.Context(), userId, req.Code)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.NoContent(w)
+}
+
+// decodeCode reads the caller and the code of an MFA request, writing the
+// error response if either is invalid
+func (c *MFAController) decodeCode(w http.ResponseWriter, r *http.Request) (string, *models.MFACodeRequest, bool) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return "", nil, false
+	}
+
+	req := &models.MFACodeRequest{}
+	err = utils.DecodeJSON(r, req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return "", nil, false
+	}
+
+	err = c.Validate.Struct(req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return "", nil, false
+	}
+
+	return userId, req, true
+}
@@ -1,11 +1,15 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/bukharney/bank-core/internal/api/models"
 	"github.com/bukharney/bank-core/internal/api/usecases"
 	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/money"
 	"github.com/bukharney/bank-core/internal/responses"
 	"github.com/bukharney/bank-core/internal/utils"
 	"github.com/go-playground/validator/v10"
@@ -22,7 +26,7 @@ type TransactionController struct {
 func NewTransactionController(cfg *config.Config, usecase *usecases.TransactionUsecase) *TransactionController {
 	return &TransactionController{
 		Cfg:      cfg,
-		Validate: validator.New(),
+		Validate: utils.NewValidator(),
 		Usecase:  usecase,
 	}
 }
@@ -32,26 +36,27 @@ func (c *TransactionController) TransferHandler(w http.ResponseWriter, r *http.R
 	transfer := &models.TransferRequest{}
 	err := utils.DecodeJSON(r, transfer)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
+		return
 	}
 
 	err = c.Validate.Struct(transfer)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
 		return
 	}
 
 	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Unauthorized(w, r, err)
 		return
 	}
 
 	transfer.UserID = userId
 
-	err = c.Usecase.Transfer(transfer)
+	err = c.Usecase.Transfer(r.Context(), transfer)
 	if err != nil {
-		responses.Error(w, http.StatusInternalServerError, err)
+		responses.Error(w, r, err)
 		return
 	}
 
@@ -63,26 +68,27 @@ func (c *TransactionController) DepositHandler(w http.ResponseWriter, r *http.Re
 	deposit := &models.DepositRequest{}
 	err := utils.DecodeJSON(r, deposit)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
+		return
 	}
 
 	err = c.Validate.Struct(deposit)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
 		return
 	}
 
 	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Unauthorized(w, r, err)
 		return
 	}
 
 	deposit.UserID = userId
 
-	err = c.Usecase.Deposit(deposit)
+	err = c.Usecase.Deposit(r.Context(), deposit)
 	if err != nil {
-		responses.Error(w, http.StatusInternalServerError, err)
+		responses.Error(w, r, err)
 		return
 	}
 
@@ -94,26 +100,27 @@ func (c *TransactionController) WithdrawHandler(w http.ResponseWriter, r *http.R
 	withdraw := &models.WithdrawalRequest{}
 	err := utils.DecodeJSON(r, withdraw)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
+		return
 	}
 
 	err = c.Validate.Struct(withdraw)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
 		return
 	}
 
 	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Unauthorized(w, r, err)
 		return
 	}
 
 	withdraw.UserID = userId
 
-	err = c.Usecase.Withdrawal(withdraw)
+	err = c.Usecase.Withdrawal(r.Context(), withdraw)
 	if err != nil {
-		responses.Error(w, http.StatusInternalServerError, err)
+		responses.Error(w, r, err)
 		return
 	}
 
@@ -129,28 +136,196 @@ func (c *TransactionController) UpdateTransactionStatusHandler(w http.ResponseWr
 	update := &models.UpdateTransactionStatusRequest{}
 	err := utils.DecodeJSON(r, update)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
+		return
 	}
 
 	err = c.Validate.Struct(update)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
 		return
 	}
 
 	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
 	if err != nil {
-		responses.Error(w, http.StatusUnauthorized, err)
+		responses.Unauthorized(w, r, err)
 		return
 	}
 
 	update.UserID = userId
 
-	err = c.Usecase.UpdateTransactionStatus(update)
+	err = c.Usecase.UpdateTransactionStatus(r.Context(), update)
 	if err != nil {
-		responses.Error(w, http.StatusInternalServerError, err)
+		responses.Error(w, r, err)
 		return
 	}
 
 	responses.JSON(w, http.StatusOK, nil)
 }
+
+// GetTransactionsHandler handles the transaction history route
+func (c *TransactionController) GetTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+	filter.UserID = userId
+
+	page, err := c.Usecase.ListTransactions(r.Context(), filter)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, page)
+}
+
+// GetAccountTransactionsHandler handles the account transaction history route
+func (c *TransactionController) GetAccountTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	id, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	accountId, err := utils.StringToInt(id)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+	filter.UserID = userId
+	filter.AccountID = &accountId
+
+	page, err := c.Usecase.ListTransactions(r.Context(), filter)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, page)
+}
+
+// GetTransactionByIDHandler handles the get transaction by ID route
+func (c *TransactionController) GetTransactionByIDHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	id, err := utils.GetIDFromRequest(r, "id")
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	transactionId, err := utils.StringToInt(id)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	transaction, err := c.Usecase.GetTransactionByID(r.Context(), userId, transactionId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, transaction)
+}
+
+/*
+parseTransactionFilter reads the history filters from the query string:
+
+	from, to                RFC 3339 timestamp or YYYY-MM-DD date (to is exclusive)
+	type, status            exact match
+	min_amount, max_amount  decimal amounts
+	cursor                  next_cursor of the previous page
+	limit                   page size
+*/
+func parseTransactionFilter(r *http.Request) (*models.TransactionFilter, error) {
+	query := r.URL.Query()
+	filter := &models.TransactionFilter{
+		Type:   query.Get("type"),
+		Status: query.Get("status"),
+	}
+
+	var err error
+	if v := query.Get("from"); v != "" {
+		filter.From, err = parseTimeParam("from", v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := query.Get("to"); v != "" {
+		filter.To, err = parseTimeParam("to", v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := query.Get("min_amount"); v != "" {
+		amount, err := money.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_amount: %w", err)
+		}
+		filter.MinAmount = &amount
+	}
+
+	if v := query.Get("max_amount"); v != "" {
+		amount, err := money.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_amount: %w", err)
+		}
+		filter.MaxAmount = &amount
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		filter.Cursor, err = models.ParseTransactionCursor(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		filter.Limit, err = utils.StringToInt(v)
+		if err != nil || filter.Limit <= 0 {
+			return nil, errors.New("invalid limit")
+		}
+	}
+
+	return filter, nil
+}
+
+// parseTimeParam parses an RFC 3339 timestamp or a YYYY-MM-DD date
+func parseTimeParam(name string, v string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: expected RFC 3339 timestamp or YYYY-MM-DD", name)
+		}
+	}
+
+	return &t, nil
+}
@@ -21,30 +21,158 @@ type UserController struct {
 func NewUserController(cfg *config.Config, usecase models.UserUsecase) *UserController {
 	return &UserController{
 		Cfg:      cfg,
-		Validate: validator.New(),
+		Validate: utils.NewValidator(),
 		Usecase:  usecase,
 	}
 }
 
 // RegisterHandler handles the registration route
 func (c *UserController) RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	user := &models.User{}
-	err := utils.DecodeJSON(r, user)
+	req := &models.RegisterRequest{}
+	err := utils.DecodeJSON(r, req)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
+		return
 	}
 
-	err = c.Validate.Struct(user)
+	// Validate the email and username in the form they are stored in
+	req.Normalize()
+	err = c.Validate.Struct(req)
 	if err != nil {
-		responses.BadRequest(w, err)
+		responses.BadRequest(w, r, err)
 		return
 	}
 
-	status, err := c.Usecase.Register(user)
+	err = c.Usecase.Register(r.Context(), req)
 	if err != nil {
-		responses.Error(w, status, err)
+		responses.Error(w, r, err)
 		return
 	}
 
 	responses.JSON(w, http.StatusCreated, nil)
 }
+
+// VerifyEmailHandler handles the verify email route, which users open from
+// the link in the verification email
+func (c *UserController) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		responses.BadRequest(w, r, models.ErrInvalidUserToken)
+		return
+	}
+
+	err := c.Usecase.VerifyEmail(r.Context(), token)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Message(w, http.StatusOK, "your email has been verified")
+}
+
+// GetMeHandler handles the get profile route
+func (c *UserController) GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	profile, err := c.Usecase.GetProfile(r.Context(), userId)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, profile)
+}
+
+// UpdateMeHandler handles the update profile route
+func (c *UserController) UpdateMeHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	req := &models.UpdateProfileRequest{}
+	err = utils.DecodeJSON(r, req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Validate.Struct(req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	profile, err := c.Usecase.UpdateProfile(r.Context(), userId, req)
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, profile)
+}
+
+// ChangeEmailHandler handles the change email route
+func (c *UserController) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := utils.GetUserIdFromRequest(c.Cfg, r, false)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	req := &models.ChangeEmailRequest{}
+	err = utils.DecodeJSON(r, req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Validate.Struct(req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	profile, err := c.Usecase.ChangeEmail(r.Context(), userId, req, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.Success(w, profile)
+}
+
+// ChangePasswordHandler handles the change password route
+func (c *UserController) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.GetTokenClaimsFromRequest(c.Cfg, r)
+	if err != nil {
+		responses.Unauthorized(w, r, err)
+		return
+	}
+
+	req := &models.ChangePasswordRequest{}
+	err = utils.DecodeJSON(r, req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Validate.Struct(req)
+	if err != nil {
+		responses.BadRequest(w, r, err)
+		return
+	}
+
+	err = c.Usecase.ChangePassword(r.Context(), claims.UserID, claims.SessionID, req, utils.GetClientInfo(r))
+	if err != nil {
+		responses.Error(w, r, err)
+		return
+	}
+
+	responses.NoContent(w)
+}
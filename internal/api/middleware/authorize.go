@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/responses"
+)
+
+// Access is who may call a route
+type Access struct {
+	// Public routes can be called without an access token
+	Public bool
+	// Permission is required on top of a valid access token, if set
+	Permission models.Permission
+}
+
+var (
+	// Public lets anyone call a route
+	Public = Access{Public: true}
+	// Authenticated lets any logged in user call a route
+	Authenticated = Access{}
+)
+
+// Require lets users whose role grants permission call a route
+func Require(permission models.Permission) Access {
+	return Access{Permission: permission}
+}
+
+// Policy maps route patterns, as labelled by Route and Routed
+// (e.g. "POST /transaction/transfer"), to who may call them
+type Policy map[string]Access
+
+/*
+Authorize enforces policy on routed requests, using the claims that
+AuthMiddleware took from the access token. It must run after Route or
+Routed has labelled the request.
+
+Routes missing from the policy are denied, so a route added without an
+entry fails closed. Requests that matched no route are passed on so the
+router can answer 404 or 405.
+*/
+func Authorize(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state, ok := r.Context().Value(requestStateKey{}).(*requestState)
+			if !ok || state.Route() == unmatchedRoute {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			access, ok := policy[state.Route()]
+			if !ok {
+				logger.WithContext(r.Context()).Errorw("route has no access policy", "route", state.Route())
+				responses.Error(w, r, models.ErrPermissionDenied)
+				return
+			}
+
+			if access.Public {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := state.Principal()
+			if err != nil {
+				responses.Error(w, r, err)
+				return
+			}
+			if claims == nil {
+				responses.Error(w, r, models.ErrMissingToken)
+				return
+			}
+
+			if access.Permission != "" && !claims.HasPermission(access.Permission) {
+				responses.Error(w, r, models.ErrPermissionDenied)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/responses"
+	"github.com/bukharney/bank-core/internal/utils"
+)
+
+var (
+	errCSRFOriginNotAllowed = apperr.Forbidden("origin_not_allowed", "request origin is not allowed")
+	errCSRFTokenMismatch    = apperr.Forbidden("csrf_token_mismatch", "missing or invalid CSRF token")
+)
+
+// safeMethods do not change state and are never checked for CSRF
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+/*
+CSRFMiddleware protects browsers that authenticate with cookies from
+cross-site request forgery.
+
+Unsafe requests carrying the auth cookies must come from our own origin or
+an allowed one, and must echo the csrf_token cookie in the X-CSRF-Token
+header. Requests with an Authorization header are not checked, since
+browsers never attach that header on their own.
+*/
+func CSRFMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if safeMethods[r.Method] || !usesCookieAuth(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(r, origin) && !cfg.CORS.AllowsOrigin(origin) {
+				responses.Error(w, r, errCSRFOriginNotAllowed)
+				return
+			}
+
+			cookie, err := r.Cookie(utils.CSRFCookieName)
+			header := r.Header.Get(utils.CSRFHeaderName)
+			if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+				responses.Error(w, r, errCSRFTokenMismatch)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// usesCookieAuth reports whether the request is authenticated by cookies
+func usesCookieAuth(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+
+	for _, name := range []string{"access_token", "refresh_token"} {
+		if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameOrigin reports whether origin is the host the request was sent to
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
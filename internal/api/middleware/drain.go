@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+/*
+InFlight tracks handlers that are still running.
+
+http.Server.Shutdown only waits for responses to be written, but
+TimeoutMiddleware answers a slow request with 503 while its handler keeps
+running in the background, possibly in the middle of a transfer. Wrapping the
+mux with InFlight lets the server wait for that work to finish before it
+closes the database and redis pools. Work a handler starts with Go, such as
+sending an email, is waited for as well.
+*/
+type InFlight struct {
+	wg sync.WaitGroup
+}
+
+// inFlightKey is the context key of the InFlight tracking a request
+type inFlightKey struct{}
+
+// NewInFlight creates a new InFlight tracker
+func NewInFlight() *InFlight {
+	return &InFlight{}
+}
+
+// Middleware counts the request as in flight until the handler returns
+func (f *InFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.wg.Add(1)
+		defer f.wg.Done()
+
+		ctx := context.WithValue(r.Context(), inFlightKey{}, f)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Go runs fn in a new goroutine that the InFlight tracking the request of
+// ctx waits for. Outside a tracked request fn simply runs in the background.
+func Go(ctx context.Context, fn func()) {
+	f, ok := ctx.Value(inFlightKey{}).(*InFlight)
+	if !ok {
+		go fn()
+		return
+	}
+
+	// The request itself is still counted, so Wait cannot have returned yet
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until every tracked handler has returned or ctx is done
+func (f *InFlight) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
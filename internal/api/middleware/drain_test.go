@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInFlightWaitsForBackgroundWork(t *testing.T) {
+	f := NewInFlight()
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	h := f.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Like an email, the work outlives the request and its context
+		Go(context.WithoutCancel(r.Context()), func() {
+			<-release
+			close(finished)
+		})
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := f.Wait(ctx); err == nil {
+		t.Fatal("Wait returned while background work was running")
+	}
+
+	close(release)
+	if err := f.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("Wait returned before the background work finished")
+	}
+}
+
+func TestGoOutsideRequest(t *testing.T) {
+	done := make(chan struct{})
+	Go(context.Background(), func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("fn did not run")
+	}
+}
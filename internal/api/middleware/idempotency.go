@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+	"github.com/bukharney/bank-core/internal/config"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/responses"
+	"github.com/bukharney/bank-core/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyKeyHeader is the request header carrying the client's idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength limits the size of keys stored in redis
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodySize limits the request bodies read to fingerprint them
+const maxIdempotentBodySize = 1 << 20
+
+// errIdempotencyInProgress rejects a replay while the first request is running
+var errIdempotencyInProgress = apperr.Conflict("idempotency_request_in_progress", "request with this idempotency key is still in progress")
+
+// idempotencyRecord is what is stored in redis for every idempotency key
+type idempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Done        bool   `json:"done"`
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// recordingResponseWriter copies the response so it can be replayed
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+// WriteHeader captures the status code and calls the original WriteHeader
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write copies the body and calls the original Write
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+/*
+Idempotency makes money-moving handlers safe to retry.
+
+When a request carries an Idempotency-Key header, the first request with that
+key (per user) is executed and its final response is stored in redis. Server
+errors are stored too: a withdrawal answers 503 after the account was debited,
+so releasing the key would let a retry move the money again. Replays
+with the same method, path and body get the stored response back without
+running the handler again. A different request with the same key, or a replay
+while the first request is still running, is rejected with 409 Conflict.
+Requests without the header are passed through unchanged.
+*/
+func Idempotency(cfg *config.Config, rdb *redis.Client) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				responses.Error(w, r, apperr.BadRequest("idempotency_key_too_long", "idempotency key is too long"))
+				return
+			}
+
+			userId, err := utils.GetUserIdFromRequest(cfg, r, false)
+			if err != nil {
+				responses.Unauthorized(w, r, err)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					responses.Error(w, r, apperr.BadRequest("request_too_large", "request body is too large"))
+					return
+				}
+				responses.BadRequest(w, r, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			sum := sha256.Sum256([]byte(r.Method + "\n" + r.URL.Path + "\n" + string(body)))
+			fingerprint := hex.EncodeToString(sum[:])
+			redisKey := "idempotency:" + userId + ":" + key
+
+			ctx := r.Context()
+			pending, err := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+			if err != nil {
+				responses.InternalServerError(w, r, err)
+				return
+			}
+
+			// Claim the key. The short TTL releases it if the server dies mid-request.
+			claimed, err := rdb.SetNX(ctx, redisKey, pending, cfg.Idempotency.LockTTL).Result()
+			if err != nil {
+				responses.InternalServerError(w, r, err)
+				return
+			}
+
+			if !claimed {
+				replayIdempotentResponse(w, r, rdb, redisKey, fingerprint)
+				return
+			}
+
+			rec := &recordingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			stored, err := json.Marshal(idempotencyRecord{
+				Fingerprint: fingerprint,
+				Done:        true,
+				StatusCode:  rec.statusCode,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+			if err != nil {
+				logger.WithContext(ctx).Errorf("Idempotency: encode response for %s: %v", redisKey, err)
+				return
+			}
+
+			// The response is stored even when the client has gone away,
+			// otherwise a retry would move the money a second time
+			err = rdb.Set(context.WithoutCancel(ctx), redisKey, stored, cfg.Idempotency.TTL).Err()
+			if err != nil {
+				logger.WithContext(ctx).Errorf("Idempotency: store response for %s: %v", redisKey, err)
+			}
+		})
+	}
+}
+
+// replayIdempotentResponse writes the stored response for an already used key
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, rdb *redis.Client, redisKey string, fingerprint string) {
+	data, err := rdb.Get(r.Context(), redisKey).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			responses.Error(w, r, errIdempotencyInProgress)
+			return
+		}
+		responses.InternalServerError(w, r, err)
+		return
+	}
+
+	record := idempotencyRecord{}
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		responses.InternalServerError(w, r, err)
+		return
+	}
+
+	if record.Fingerprint != fingerprint {
+		responses.Error(w, r, apperr.Conflict("idempotency_key_reused", "idempotency key was already used with a different request"))
+		return
+	}
+
+	if !record.Done {
+		responses.Error(w, r, errIdempotencyInProgress)
+		return
+	}
+
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
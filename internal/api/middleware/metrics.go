@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/metrics"
+	"github.com/bukharney/bank-core/internal/utils"
+)
+
+// unmatchedRoute labels requests that no route handled, so unknown paths
+// do not create a new time series each
+const unmatchedRoute = "unmatched"
+
+type requestStateKey struct{}
+
+// requestState is shared by the outer middlewares and the route handlers.
+// The handler may run in the TimeoutMiddleware goroutine, hence the mutex.
+type requestState struct {
+	mu     sync.Mutex
+	route  string
+	userID string
+	// claims of the access token, nil for anonymous requests
+	claims *utils.TokenClaims
+	// authErr is why the credentials of the request were rejected
+	authErr error
+}
+
+// withRequestState returns the request state of r, adding one if needed
+func withRequestState(r *http.Request) (*http.Request, *requestState) {
+	if state, ok := r.Context().Value(requestStateKey{}).(*requestState); ok {
+		return r, state
+	}
+
+	state := &requestState{route: unmatchedRoute}
+	return r.WithContext(context.WithValue(r.Context(), requestStateKey{}, state)), state
+}
+
+// setRoute records the matched route pattern of the request
+func setRoute(r *http.Request, route string) {
+	if state, ok := r.Context().Value(requestStateKey{}).(*requestState); ok {
+		state.mu.Lock()
+		state.route = route
+		state.mu.Unlock()
+	}
+}
+
+// setPrincipal records the access token claims of the authenticated user
+func setPrincipal(r *http.Request, claims *utils.TokenClaims) {
+	if state, ok := r.Context().Value(requestStateKey{}).(*requestState); ok {
+		state.mu.Lock()
+		state.userID = claims.UserID
+		state.claims = claims
+		state.mu.Unlock()
+	}
+}
+
+// setAuthError records why the credentials of the request were rejected
+func setAuthError(r *http.Request, err error) {
+	if state, ok := r.Context().Value(requestStateKey{}).(*requestState); ok {
+		state.mu.Lock()
+		state.authErr = err
+		state.mu.Unlock()
+	}
+}
+
+// Route returns the matched route pattern, e.g. "GET /account/{id}"
+func (s *requestState) Route() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.route
+}
+
+// UserID returns the authenticated user, or "" for anonymous requests
+func (s *requestState) UserID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.userID
+}
+
+// Principal returns the access token claims and the authentication error
+// of the request, both nil for requests without credentials
+func (s *requestState) Principal() (*utils.TokenClaims, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claims, s.authErr
+}
+
+// Route labels requests handled by h with pattern, then passes them through
+// middlewares that depend on the route, such as Authorize
+func Route(pattern string, h http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	h = ChainMiddleware(middlewares...)(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setRoute(r, pattern)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Routed labels requests handled by a sub-router mounted under prefix
+// with the full pattern, e.g. "POST /transaction/transfer", then passes
+// them through middlewares that depend on the route, such as Authorize
+func Routed(prefix string, mux *http.ServeMux, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	h := ChainMiddleware(middlewares...)(mux)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			setRoute(r, RoutePattern(prefix, pattern))
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RoutePattern returns the full pattern of a route registered as pattern on a
+// sub-router mounted under prefix, e.g. "POST /transaction/transfer"
+func RoutePattern(prefix string, pattern string) string {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+
+	return strings.TrimSpace(method + " " + prefix + path)
+}
+
+// MetricsMiddleware records request counts and latency by route pattern and status
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, state := withRequestState(r)
+		srw := &statusResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(srw, r)
+
+		status := strconv.Itoa(srw.statusCode)
+		route := state.Route()
+		method := methodLabel(r.Method)
+		metrics.HTTPRequests.WithLabelValues(method, route, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// otherMethod labels requests whose method is not a standard one
+const otherMethod = "other"
+
+// methodLabel returns method as a metric label. Clients can send any
+// method, so everything outside the standard ones shares a single series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return otherMethod
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bukharney/bank-core/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMethodLabel(t *testing.T) {
+	tests := map[string]string{
+		http.MethodGet:    http.MethodGet,
+		http.MethodPost:   http.MethodPost,
+		http.MethodDelete: http.MethodDelete,
+		"PROPFIND":        otherMethod,
+		"get":             otherMethod,
+		"X-RANDOM-12345":  otherMethod,
+	}
+
+	for method, want := range tests {
+		if got := methodLabel(method); got != want {
+			t.Errorf("methodLabel(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
+
+func TestMetricsMiddlewareBoundsMethods(t *testing.T) {
+	h := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	other := metrics.HTTPRequests.WithLabelValues(otherMethod, unmatchedRoute, "200")
+	before := testutil.ToFloat64(other)
+
+	for _, method := range []string{"FOO", "BAR", "BAZ"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil))
+	}
+
+	if got := testutil.ToFloat64(other) - before; got != 3 {
+		t.Errorf("other method series grew by %v, want 3", got)
+	}
+}
@@ -1,26 +1,37 @@
 package middleware
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
 	"runtime/debug"
 	"time"
 
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/apperr"
 	"github.com/bukharney/bank-core/internal/config"
 	logger "github.com/bukharney/bank-core/internal/logs"
 	"github.com/bukharney/bank-core/internal/responses"
 	"github.com/bukharney/bank-core/internal/utils"
+	"go.uber.org/zap/zapcore"
 )
 
-var unprotectedRoutes = map[string]bool{
-	"/user/register": true,
-	"/auth/login":    true,
-	"/auth/test":     true,
-}
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern limits inbound request IDs to short, log-safe tokens
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
 
 // statusResponseWriter wraps http.ResponseWriter to capture the status code
+// and the size of the body
 type statusResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int
 }
 
 // WriteHeader captures the status code and calls the original WriteHeader
@@ -29,64 +40,182 @@ func (w *statusResponseWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
-// Write calls the original Write and returns the status code
+// Write calls the original Write and counts the bytes written
 func (w *statusResponseWriter) Write(b []byte) (int, error) {
-	return w.ResponseWriter.Write(b)
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
 }
 
-// TimeoutMiddleware adds a timeout to the request
-func TimeoutMiddleware(next http.Handler) http.Handler {
-	timeout := 1
-	return http.TimeoutHandler(next, time.Duration(timeout)*time.Second, "Request timed out")
+// Unwrap lets http.ResponseController reach the connection underneath
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
-// AuthMiddleware checks if the user is authenticated
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg := config.NewConfig()
-		if _, ok := unprotectedRoutes[r.URL.Path]; ok {
-			next.ServeHTTP(w, r)
-			return
+// Timeouts maps route patterns, as labelled by Route and Routed
+// (e.g. "POST /kyc/documents"), to the time they may take
+type Timeouts map[string]time.Duration
+
+/*
+TimeoutMiddleware adds a timeout to the request.
+
+Routes listed in long are served outside http.TimeoutHandler, which buffers
+the whole response in memory and cuts it at server.request_timeout. They get
+their own deadline instead, on both the request context and the connection,
+so a document upload or download streams for as long as its timeout allows.
+*/
+func TimeoutMiddleware(cfg *config.Config, long Timeouts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		timeout := http.TimeoutHandler(next, cfg.Server.RequestTimeout, "Request timed out")
+
+		exempt := http.NewServeMux()
+		for pattern, d := range long {
+			exempt.Handle(pattern, deadlineHandler(next, d))
 		}
 
-		token, err := utils.ExtractToken(r, "access_token")
-		if err != nil {
-			if err.Error() == "http: named cookie not present" {
-				responses.Unauthorized(w, err)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h, pattern := exempt.Handler(r); pattern != "" {
+				h.ServeHTTP(w, r)
 				return
 			}
 
-			responses.BadRequest(w, err)
-			return
-		}
-		if !utils.ValidateToken(cfg, token, false) {
-			responses.Unauthorized(w, err)
-			return
+			timeout.ServeHTTP(w, r)
+		})
+	}
+}
+
+// deadlineHandler bounds the request by d, moving the read and write
+// deadlines of the connection past the server-wide ones
+func deadlineHandler(next http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline := time.Now().Add(d)
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		// Writers that cannot reach the connection keep the server deadlines
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(deadline)
+		_ = rc.SetWriteDeadline(deadline)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+/*
+AuthMiddleware authenticates the access token of the request, if it has one.
+
+It does not reject requests by itself: Authorize decides once the route is
+known, since public routes such as the refresh route accept requests without
+an access token, or with a refresh token in its place.
+
+Access tokens are checked against the denylist of revoked sessions, so a
+session that was logged out or revoked stops working before its access token
+expires.
+*/
+func AuthMiddleware(cfg *config.Config, repo models.AuthRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := utils.ExtractToken(r, "access_token")
+			if err != nil {
+				if !errors.Is(err, http.ErrNoCookie) {
+					setAuthError(r, err)
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := utils.ParseTokenClaims(cfg, token, false)
+			if err != nil || claims.SessionID == "" {
+				setAuthError(r, models.ErrInvalidAccessToken)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			revoked, err := repo.IsSessionRevoked(r.Context(), claims.SessionID)
+			if err != nil {
+				setAuthError(r, apperr.Unavailable("session_store_unavailable", "could not check the session", err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			if revoked {
+				setAuthError(r, models.ErrSessionRevoked)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			setPrincipal(r, claims)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestIDMiddleware gives every request an ID, reusing a well-formed
+// X-Request-ID from the client or proxy, and echoes it in the response
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
 		}
 
-		next.ServeHTTP(w, r)
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(logger.ContextWithRequestID(r.Context(), requestID)))
 	})
 }
 
-// LoggerMiddleware logs the request and response
+// newRequestID returns a random 128-bit hex ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LoggerMiddleware logs one structured line per request. Server errors are
+// logged at error level and client errors at warn level.
 func LoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Wrap the ResponseWriter to capture the status code
+		start := time.Now()
+		r, state := withRequestState(r)
+		// Wrap the ResponseWriter to capture the status code and size
 		srw := &statusResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
 		next.ServeHTTP(srw, r)
-		logger.Logger.Infof("[%s] %s %s %d", r.Method, r.URL.Path, r.RemoteAddr, srw.statusCode)
+
+		level := zapcore.InfoLevel
+		if srw.statusCode >= http.StatusInternalServerError {
+			level = zapcore.ErrorLevel
+		} else if srw.statusCode >= http.StatusBadRequest {
+			level = zapcore.WarnLevel
+		}
+
+		logger.WithContext(r.Context()).Logw(level, "request",
+			"method", r.Method,
+			"route", state.Route(),
+			"path", r.URL.Path,
+			"status", srw.statusCode,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", srw.bytes,
+			"user_id", state.UserID(),
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
 	})
 }
 
-// PanicMiddleware recovers from panics and logs the error
+// PanicMiddleware recovers from panics and logs the error with its stack trace
 func PanicMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			if r := recover(); r != nil {
-				debug.PrintStack()
-				logger.Logger.Errorf("Panic: %v", r)
-				responses.Error(w, http.StatusInternalServerError, nil)
+			if rec := recover(); rec != nil {
+				logger.WithContext(r.Context()).Errorw("panic",
+					"panic", fmt.Sprint(rec),
+					"method", r.Method,
+					"path", r.URL.Path,
+					"stack", string(debug.Stack()),
+				)
+				responses.Error(w, r, nil)
 			}
 		}()
 
@@ -94,15 +223,37 @@ func PanicMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// CORSMiddleware adds the necessary headers for CORS
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+/*
+CORSMiddleware lets browsers on the configured origins call the API with
+cookies, and answers their preflight requests. Requests from other origins
+get no CORS headers, so browsers do not expose the responses to them.
+*/
+func CORSMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
 
-		next.ServeHTTP(w, r)
-	})
+			origin := r.Header.Get("Origin")
+			if origin == "" || !cfg.CORS.AllowsOrigin(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, X-Request-ID, X-CSRF-Token")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // ChainMiddleware chains multiple middlewares together
@@ -116,20 +267,26 @@ func ChainMiddleware(middlewares ...func(http.Handler) http.Handler) func(http.H
 }
 
 // DefaultMiddleware is the default middleware chain
-var DefaultMiddleware = ChainMiddleware(
-	LoggerMiddleware,
-	PanicMiddleware,
-	AuthMiddleware,
-	CORSMiddleware,
-	TimeoutMiddleware,
-)
+func DefaultMiddleware(cfg *config.Config, authRepo models.AuthRepository, timeouts Timeouts) func(http.Handler) http.Handler {
+	return ChainMiddleware(
+		MetricsMiddleware,
+		RequestIDMiddleware,
+		TracingMiddleware,
+		LoggerMiddleware,
+		PanicMiddleware,
+		CORSMiddleware(cfg),
+		CSRFMiddleware(cfg),
+		AuthMiddleware(cfg, authRepo),
+		TimeoutMiddleware(cfg, timeouts),
+	)
+}
 
 // ApplyMiddleware applies the default middleware chain to a handler
-func ApplyMiddleware(handler http.Handler) http.Handler {
-	return DefaultMiddleware(handler)
+func ApplyMiddleware(cfg *config.Config, authRepo models.AuthRepository, timeouts Timeouts, handler http.Handler) http.Handler {
+	return DefaultMiddleware(cfg, authRepo, timeouts)(handler)
 }
 
 // ApplyMiddlewareFunc applies the default middleware chain to a handler function
-func ApplyMiddlewareFunc(handlerFunc http.HandlerFunc) http.Handler {
-	return ApplyMiddleware(http.HandlerFunc(handlerFunc))
+func ApplyMiddlewareFunc(cfg *config.Config, authRepo models.AuthRepository, timeouts Timeouts, handlerFunc http.HandlerFunc) http.Handler {
+	return ApplyMiddleware(cfg, authRepo, timeouts, http.HandlerFunc(handlerFunc))
 }
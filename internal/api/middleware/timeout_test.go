@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/config"
+)
+
+// slowHandler flushes the first line at once and the second after delay
+func slowHandler(delay time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first\n")
+		http.NewResponseController(w).Flush()
+
+		select {
+		case <-time.After(delay):
+			io.WriteString(w, "second\n")
+		case <-r.Context().Done():
+		}
+	})
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Server.RequestTimeout = 50 * time.Millisecond
+
+	long := Timeouts{"GET /download": time.Second}
+	server := httptest.NewServer(TimeoutMiddleware(cfg, long)(slowHandler(200 * time.Millisecond)))
+	defer server.Close()
+
+	t.Run("other routes time out", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/other")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("got %d, want %d", res.StatusCode, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("long routes stream past the request timeout", func(t *testing.T) {
+		start := time.Now()
+		res, err := http.Get(server.URL + "/download")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		body := bufio.NewReader(res.Body)
+		first, err := body.ReadString('\n')
+		if err != nil || first != "first\n" {
+			t.Fatalf("first line: %q, %v", first, err)
+		}
+		if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+			t.Errorf("first line arrived after %v, the response was buffered", elapsed)
+		}
+
+		second, err := body.ReadString('\n')
+		if err != nil || second != "second\n" {
+			t.Errorf("second line: %q, %v", second, err)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// untracedRoutes are polled by infrastructure and would drown real traces
+var untracedRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// TracingMiddleware starts a server span for every request, continuing the
+// trace of the caller when it sent a traceparent header. The span is named
+// after the matched route pattern once the request has been routed.
+func TracingMiddleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		state, ok := r.Context().Value(requestStateKey{}).(*requestState)
+		if !ok {
+			return
+		}
+
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(attribute.String("http.request_id", logger.RequestID(r.Context())))
+
+		if route := state.Route(); route != unmatchedRoute {
+			span.SetName(route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+	})
+
+	return otelhttp.NewHandler(named, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !untracedRoutes[r.URL.Path]
+		}),
+	)
+}
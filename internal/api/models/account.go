@@ -1,33 +1,35 @@
 package models
 
 import (
+	"context"
 	"time"
 
+	"github.com/bukharney/bank-core/internal/money"
 	"github.com/google/uuid"
 )
 
 type AccountRepository interface {
-	GetAccountByID(accountID int) (*Account, error)
-	GetAccountsByUserID(userID string) (*[]Account, error)
-	CreateAccount(account *CreateAccountRequest) error
+	GetAccountByID(ctx context.Context, accountID int) (*Account, error)
+	GetAccountsByUserID(ctx context.Context, userID string) (*[]Account, error)
+	CreateAccount(ctx context.Context, account *CreateAccountRequest) error
 }
 
 type AccountUsecase interface {
-	GetAccountByID(accountID string) (*Account, error)
-	GetAccountsByUserID(userID string) (*[]Account, error)
-	CreateAccount(userID string) error
+	GetAccountByID(ctx context.Context, accountID string) (*Account, error)
+	GetAccountsByUserID(ctx context.Context, userID string) (*[]Account, error)
+	CreateAccount(ctx context.Context, userID string) error
 }
 
 type Account struct {
-	ID          int       `json:"id" db:"id"`
-	UserID      uuid.UUID `json:"user_id" db:"user_id"`
-	Balance     float64   `json:"balance" db:"balance"`
-	AccountType string    `json:"account_type" db:"account_type"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ID          int         `json:"id" db:"id"`
+	UserID      uuid.UUID   `json:"user_id" db:"user_id"`
+	Balance     money.Money `json:"balance" db:"balance"`
+	AccountType string      `json:"account_type" db:"account_type"`
+	CreatedAt   time.Time   `json:"created_at" db:"created_at"`
 }
 
 type CreateAccountRequest struct {
-	UserID      string `json:"user_id" db:"user_id"`
-	Balance     int    `json:"balance" db:"balance"`
-	AccountType string `json:"account_type" db:"account_type"`
+	UserID      string      `json:"user_id" db:"user_id"`
+	Balance     money.Money `json:"balance" db:"balance"`
+	AccountType string      `json:"account_type" db:"account_type"`
 }
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// Types of audit events
+const (
+	AuditLoginLocked     = "login.locked"
+	AuditLoginUnlocked   = "login.unlocked"
+	AuditPasswordReset   = "password.reset"
+	AuditPasswordChanged = "password.changed"
+	AuditEmailChanged    = "email.changed"
+	AuditKYCSubmitted    = "kyc.submitted"
+	AuditKYCApproved     = "kyc.approved"
+	AuditKYCRejected     = "kyc.rejected"
+)
+
+type AuditRepository interface {
+	RecordEvent(ctx context.Context, event *AuditEvent) error
+}
+
+/*
+AuditEvent is a security relevant event kept in the audit trail.
+
+UserID is the user the event is about and ActorID the user who caused it,
+such as the admin who unlocked an account. Both are empty when unknown.
+*/
+type AuditEvent struct {
+	ID        int64             `json:"id" db:"id"`
+	Type      string            `json:"type" db:"event_type"`
+	UserID    *string           `json:"user_id,omitempty" db:"user_id"`
+	ActorID   *string           `json:"actor_id,omitempty" db:"actor_id"`
+	IP        string            `json:"ip,omitempty" db:"ip"`
+	Details   map[string]string `json:"details,omitempty" db:"-"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+}
@@ -1,14 +1,47 @@
 package models
 
+import (
+	"context"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+)
+
+var (
+	ErrInvalidCredentials  = apperr.Unauthorized("invalid_credentials", "invalid email or password")
+	ErrInvalidAccessToken  = apperr.Unauthorized("invalid_access_token", "invalid access token")
+	ErrInvalidRefreshToken = apperr.Unauthorized("invalid_refresh_token", "invalid refresh token")
+	ErrMissingToken        = apperr.Unauthorized("missing_token", "authentication token is missing")
+	ErrSessionNotFound     = apperr.Unauthorized("session_not_found", "session has expired or was revoked")
+	ErrRefreshTokenReused  = apperr.Unauthorized("refresh_token_reused", "refresh token was already used, the session has been revoked")
+	ErrSessionRevoked      = apperr.Unauthorized("session_revoked", "session has been revoked")
+	ErrUnknownSession      = apperr.NotFound("unknown_session", "session not found")
+)
+
 type AuthUsecase interface {
-	Login(user *UserCredentials) (*LoginResponse, error)
-	Logout(refreshToken string) error
-	RefreshToken(refreshToken string) (*LoginResponse, error)
-	Me(token string) (*User, error)
+	Login(ctx context.Context, user *UserCredentials, client *ClientInfo) (*LoginResponse, error)
+	LoginMFA(ctx context.Context, req *MFALoginRequest, client *ClientInfo) (*LoginResponse, error)
+	Logout(ctx context.Context, refreshToken string) error
+	RefreshToken(ctx context.Context, refreshToken string, client *ClientInfo) (*LoginResponse, error)
+	Me(ctx context.Context, token string) (*Profile, error)
+	ListSessions(ctx context.Context, userId string, currentSessionId string) ([]*Session, error)
+	RevokeSession(ctx context.Context, userId string, sessionId string) error
+	LogoutAll(ctx context.Context, userId string) error
+	ListUserSessions(ctx context.Context, userId string) ([]*Session, error)
+	RevokeUserSessions(ctx context.Context, adminId string, userId string) error
+	AssignRole(ctx context.Context, adminId string, userId string, role string) error
+	UnlockUser(ctx context.Context, adminId string, userId string, client *ClientInfo) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, req *ResetPasswordRequest, client *ClientInfo) error
 }
 
 type AuthRepository interface {
-	UpdateRefreshToken(userId string, refreshToken string) error
+	CreateSession(ctx context.Context, session *Session) error
+	GetSession(ctx context.Context, sessionId string) (*Session, error)
+	RotateSession(ctx context.Context, session *Session, usedTokenId string) error
+	ListSessions(ctx context.Context, userId string) ([]*Session, error)
+	DeleteSession(ctx context.Context, session *Session) error
+	IsSessionRevoked(ctx context.Context, sessionId string) (bool, error)
 }
 
 type UserCredentials struct {
@@ -17,6 +50,42 @@ type UserCredentials struct {
 }
 
 type LoginResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// MFARequired is set instead of the tokens when the user has enabled
+	// MFA, and MFAToken must be sent with a code to finish logging in
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	// CSRFToken is set for browsers using the cookies, which must send it
+	// back in the X-CSRF-Token header
+	CSRFToken string `json:"csrf_token,omitempty"`
+}
+
+// ClientInfo describes the device a request was made from
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+/*
+Session is a login on one device.
+
+Every refresh token issued for the session belongs to the same token family,
+identified by the session ID. Only the most recently issued refresh token,
+TokenID, is valid: refreshing rotates it, and presenting an older one means
+the token was stolen or replayed, so the whole session is revoked.
+Role is the role of the user at login, sessions are revoked when it changes.
+*/
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Role       string    `json:"role"`
+	TokenID    string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current is set when listing sessions on the session of the caller
+	Current bool `json:"current"`
 }
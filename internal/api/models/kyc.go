@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+	"github.com/google/uuid"
+)
+
+// States of a user's identity verification
+const (
+	KYCPending   = "pending"
+	KYCSubmitted = "submitted"
+	KYCApproved  = "approved"
+	KYCRejected  = "rejected"
+)
+
+// Types of KYC documents
+const (
+	DocumentPassport       = "passport"
+	DocumentNationalID     = "national_id"
+	DocumentDriversLicense = "drivers_license"
+	DocumentProofOfAddress = "proof_of_address"
+)
+
+var (
+	ErrKYCNotFound          = apperr.NotFound("kyc_not_found", "identity verification not found")
+	ErrKYCNotApproved       = apperr.Forbidden("kyc_not_approved", "your identity must be verified to perform this action")
+	ErrKYCInvalidTransition = apperr.Conflict("kyc_invalid_transition", "identity verification cannot move to this state")
+	ErrKYCDocumentsLocked   = apperr.Conflict("kyc_documents_locked", "documents cannot be changed while under review or once approved")
+	ErrKYCIdentityMissing   = apperr.BadRequest("kyc_identity_missing", "upload a passport, national ID or driver's license first")
+	ErrKYCOwnReview         = apperr.Forbidden("kyc_own_review", "compliance officers cannot review their own verification")
+	ErrUnknownDocumentType  = apperr.BadRequest("unknown_document_type", "unknown document type")
+	ErrDocumentTooLarge     = apperr.BadRequest("document_too_large", "the document is too large")
+	ErrDocumentContentType  = apperr.BadRequest("unsupported_document", "documents must be a JPEG, PNG or PDF file")
+	ErrDocumentNotFound     = apperr.NotFound("document_not_found", "document not found")
+	ErrUnknownKYCStatus     = apperr.BadRequest("unknown_kyc_status", "unknown verification status")
+)
+
+/*
+kycTransitions are the states a verification can move to from every state.
+
+Users submit their documents for review, and resubmit them after a
+rejection. Only compliance officers approve or reject a submission.
+Approved is final.
+*/
+var kycTransitions = map[string][]string{
+	KYCPending:   {KYCSubmitted},
+	KYCRejected:  {KYCSubmitted},
+	KYCSubmitted: {KYCApproved, KYCRejected},
+}
+
+// identityDocuments are the document types that prove who a user is
+var identityDocuments = map[string]bool{
+	DocumentPassport:       true,
+	DocumentNationalID:     true,
+	DocumentDriversLicense: true,
+}
+
+type KYCUsecase interface {
+	GetKYC(ctx context.Context, userId string) (*KYC, error)
+	UploadDocument(ctx context.Context, userId string, documentType string, r io.Reader) (*KYCDocument, error)
+	Submit(ctx context.Context, userId string, client *ClientInfo) (*KYC, error)
+	RequireApproved(ctx context.Context, userId string) error
+	ListReviews(ctx context.Context, status string) ([]*KYC, error)
+	OpenDocument(ctx context.Context, documentId string) (*KYCDocument, io.ReadCloser, error)
+	Approve(ctx context.Context, reviewerId string, userId string, client *ClientInfo) (*KYC, error)
+	Reject(ctx context.Context, reviewerId string, userId string, reason string, client *ClientInfo) (*KYC, error)
+}
+
+type KYCRepository interface {
+	GetKYC(ctx context.Context, userId string) (*KYC, error)
+	ListKYC(ctx context.Context, status string, limit int) ([]*KYC, error)
+	UpdateKYCStatus(ctx context.Context, kyc *KYC, from string) error
+	GetDocuments(ctx context.Context, userId string) ([]*KYCDocument, error)
+	GetDocument(ctx context.Context, id string) (*KYCDocument, error)
+	SaveDocument(ctx context.Context, document *KYCDocument) (string, error)
+}
+
+/*
+KYC is the identity verification of a user.
+
+ReviewerID, ReviewedAt and RejectionReason describe the last decision of a
+compliance officer, RejectionReason is only set while the state is rejected.
+*/
+type KYC struct {
+	UserID          uuid.UUID      `json:"user_id" db:"user_id"`
+	Status          string         `json:"status" db:"status"`
+	SubmittedAt     *time.Time     `json:"submitted_at" db:"submitted_at"`
+	ReviewedAt      *time.Time     `json:"reviewed_at" db:"reviewed_at"`
+	ReviewerID      *uuid.UUID     `json:"reviewer_id,omitempty" db:"reviewer_id"`
+	RejectionReason *string        `json:"rejection_reason,omitempty" db:"rejection_reason"`
+	UpdatedAt       time.Time      `json:"updated_at" db:"updated_at"`
+	Documents       []*KYCDocument `json:"documents" db:"-"`
+}
+
+// CanTransition reports whether the verification can move to status
+func (k *KYC) CanTransition(status string) bool {
+	for _, next := range kycTransitions[k.Status] {
+		if next == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanUpload reports whether documents can be uploaded in the current state
+func (k *KYC) CanUpload() bool {
+	return k.CanTransition(KYCSubmitted)
+}
+
+// HasIdentityDocument reports whether a document proving who the user is
+// was uploaded
+func (k *KYC) HasIdentityDocument() bool {
+	for _, document := range k.Documents {
+		if identityDocuments[document.Type] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// KYCDocument is an uploaded identity document, its file is in the blob store
+type KYCDocument struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	UserID      uuid.UUID `json:"user_id" db:"user_id"`
+	Type        string    `json:"type" db:"document_type"`
+	BlobKey     string    `json:"-" db:"blob_key"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int64     `json:"size" db:"size_bytes"`
+	UploadedAt  time.Time `json:"uploaded_at" db:"uploaded_at"`
+}
+
+// IsDocumentType reports whether documentType is a known document type
+func IsDocumentType(documentType string) bool {
+	return identityDocuments[documentType] || documentType == DocumentProofOfAddress
+}
+
+// IsKYCStatus reports whether status is a known verification state
+func IsKYCStatus(status string) bool {
+	switch status {
+	case KYCPending, KYCSubmitted, KYCApproved, KYCRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// RejectKYCRequest is the request to reject a verification, the reason is
+// shown to the user
+type RejectKYCRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
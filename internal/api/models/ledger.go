@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/jmoiron/sqlx"
+)
+
+// System ledger account codes seeded by the migrations
+const (
+	LedgerBankCash         = "BANK_CASH"
+	LedgerATMCashInTransit = "ATM_CASH_IN_TRANSIT"
+	LedgerFeeIncome        = "FEE_INCOME"
+	// LedgerOpeningBalanceEquity balances the opening entries of accounts
+	// that held money before the ledger existed
+	LedgerOpeningBalanceEquity = "OPENING_BALANCE_EQUITY"
+)
+
+type LedgerRepository interface {
+	Post(ctx context.Context, tx *sqlx.Tx, entry *JournalEntry) error
+	GetCustomerLedgerAccountID(ctx context.Context, tx *sqlx.Tx, accountID int) (int, error)
+	GetSystemLedgerAccountID(ctx context.Context, tx *sqlx.Tx, code string) (int, error)
+	GetTrialBalance(ctx context.Context) ([]*TrialBalanceLine, error)
+}
+
+type LedgerUsecase interface {
+	GetTrialBalance(ctx context.Context, userID string) (*TrialBalance, error)
+}
+
+type LedgerAccount struct {
+	ID         int       `json:"id" db:"id"`
+	Code       string    `json:"code" db:"code"`
+	Name       string    `json:"name" db:"name"`
+	LedgerType string    `json:"ledger_type" db:"ledger_type"`
+	AccountID  *int      `json:"account_id" db:"account_id"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// JournalEntry is a balanced set of journal lines recording one money movement
+type JournalEntry struct {
+	ID            int           `json:"id" db:"id"`
+	TransactionID *int          `json:"transaction_id" db:"transaction_id"`
+	Description   string        `json:"description" db:"description"`
+	Lines         []JournalLine `json:"lines" db:"-"`
+	CreatedAt     time.Time     `json:"created_at" db:"created_at"`
+}
+
+// JournalLine is a single debit (positive amount) or credit (negative amount)
+type JournalLine struct {
+	ID              int         `json:"id" db:"id"`
+	EntryID         int         `json:"entry_id" db:"entry_id"`
+	LedgerAccountID int         `json:"ledger_account_id" db:"ledger_account_id"`
+	Amount          money.Money `json:"amount" db:"amount"`
+}
+
+// Debit creates a journal line debiting the ledger account
+func Debit(ledgerAccountID int, amount money.Money) JournalLine {
+	return JournalLine{LedgerAccountID: ledgerAccountID, Amount: amount}
+}
+
+// Credit creates a journal line crediting the ledger account
+func Credit(ledgerAccountID int, amount money.Money) JournalLine {
+	return JournalLine{LedgerAccountID: ledgerAccountID, Amount: amount.Neg()}
+}
+
+// CustomerLedgerCode returns the ledger account code of a customer account
+func CustomerLedgerCode(accountID int) string {
+	return "CUST-" + strconv.Itoa(accountID)
+}
+
+type TrialBalanceLine struct {
+	LedgerAccountID int         `json:"ledger_account_id" db:"id"`
+	Code            string      `json:"code" db:"code"`
+	Name            string      `json:"name" db:"name"`
+	LedgerType      string      `json:"ledger_type" db:"ledger_type"`
+	Debit           money.Money `json:"debit" db:"debit"`
+	Credit          money.Money `json:"credit" db:"credit"`
+}
+
+type TrialBalance struct {
+	Lines       []*TrialBalanceLine `json:"lines"`
+	TotalDebit  money.Money         `json:"total_debit"`
+	TotalCredit money.Money         `json:"total_credit"`
+	Balanced    bool                `json:"balanced"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+)
+
+// Scopes failed logins are counted in
+const (
+	LoginScopeEmail = "email"
+	LoginScopeIP    = "ip"
+	// LoginScopeMFA counts invalid MFA codes by user ID
+	LoginScopeMFA = "mfa"
+)
+
+type LockoutRepository interface {
+	GetLoginBlock(ctx context.Context, scope string, subject string) (*LoginBlock, error)
+	RecordLoginFailure(ctx context.Context, scope string, subject string, window time.Duration) (int64, error)
+	BlockLogin(ctx context.Context, scope string, subject string, block *LoginBlock) error
+	ClearLoginFailures(ctx context.Context, scope string, subject string) error
+	UnblockLogin(ctx context.Context, scope string, subject string) error
+}
+
+/*
+LoginBlock stops logins of an email or from an IP for a while.
+
+After each failed login the email is delayed for a time that doubles with
+every failure, and once too many logins failed within the window the email
+or IP is locked out for the lockout duration.
+*/
+type LoginBlock struct {
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+// Err returns the error logins get while the block lasts
+func (b *LoginBlock) Err() error {
+	if b.Locked {
+		return apperr.TooManyRequests("login_locked", "too many failed logins, try again later", b.RetryAfter)
+	}
+
+	return apperr.TooManyRequests("login_throttled", "wait before trying to log in again", b.RetryAfter)
+}
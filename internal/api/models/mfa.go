@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+)
+
+var (
+	ErrMFANotEnrolled        = apperr.Conflict("mfa_not_enrolled", "start MFA enrollment first")
+	ErrMFAAlreadyEnabled     = apperr.Conflict("mfa_already_enabled", "MFA is already enabled")
+	ErrMFANotEnabled         = apperr.Conflict("mfa_not_enabled", "MFA is not enabled")
+	ErrInvalidMFACode        = apperr.Unauthorized("invalid_mfa_code", "invalid MFA code")
+	ErrInvalidMFAChallenge   = apperr.Unauthorized("invalid_mfa_challenge", "MFA challenge has expired or is invalid")
+	ErrMFARequired           = apperr.Forbidden("mfa_required", "an MFA code is required for this action")
+	ErrMFAEnrollmentRequired = apperr.Forbidden("mfa_enrollment_required", "enable MFA to perform this action")
+)
+
+// MFALockedError is returned while the MFA codes of a user are locked out
+// after too many invalid ones
+func MFALockedError(retryAfter time.Duration) error {
+	return apperr.TooManyRequests("mfa_locked", "too many invalid MFA codes, try again later", retryAfter)
+}
+
+type MFAUsecase interface {
+	Enroll(ctx context.Context, userId string) (*MFAEnrollment, error)
+	Confirm(ctx context.Context, userId string, code string) (*RecoveryCodes, error)
+	Disable(ctx context.Context, userId string, code string) error
+	IsEnabled(ctx context.Context, userId string) (bool, error)
+	Verify(ctx context.Context, userId string, code string) error
+	StartChallenge(ctx context.Context, userId string) (string, error)
+	CompleteChallenge(ctx context.Context, token string, code string) (string, error)
+}
+
+type MFARepository interface {
+	GetMFA(ctx context.Context, userId string) (*MFA, error)
+	SaveMFASecret(ctx context.Context, userId string, secret string) error
+	EnableMFA(ctx context.Context, userId string, codeHashes []string) error
+	DisableMFA(ctx context.Context, userId string) error
+	UseMFAStep(ctx context.Context, userId string, step int64) (bool, error)
+	GetRecoveryCodes(ctx context.Context, userId string) ([]*RecoveryCode, error)
+	UseRecoveryCode(ctx context.Context, id int) (bool, error)
+	CreateMFAChallenge(ctx context.Context, token string, userId string, ttl time.Duration) error
+	AttemptMFAChallenge(ctx context.Context, token string) (string, int64, error)
+	DeleteMFAChallenge(ctx context.Context, token string) error
+}
+
+// MFA is the TOTP enrollment of a user
+type MFA struct {
+	UserID string `db:"user_id"`
+	// Secret is encrypted at rest
+	Secret    string     `db:"secret"`
+	Enabled   bool       `db:"enabled"`
+	LastStep  int64      `db:"last_step"`
+	CreatedAt time.Time  `db:"created_at"`
+	EnabledAt *time.Time `db:"enabled_at"`
+}
+
+// RecoveryCode is an unused recovery code
+type RecoveryCode struct {
+	ID       int    `db:"id"`
+	CodeHash string `db:"code_hash"`
+}
+
+// MFAEnrollment is returned when enrollment starts, for the user to add
+// the secret to an authenticator app
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// RecoveryCodes are shown once, when MFA is enabled
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// MFACodeRequest carries a TOTP or recovery code
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// MFALoginRequest is the second step of a login with MFA
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+)
+
+// DateLayout is the format of dates such as the date of birth
+const DateLayout = "2006-01-02"
+
+var (
+	ErrWrongPassword = apperr.Forbidden("wrong_password", "current password is incorrect")
+	ErrSameEmail     = apperr.BadRequest("same_email", "the new email is the current email")
+)
+
+// Profile is what users see of their own account. It never includes the
+// password hash or other internal fields of User.
+type Profile struct {
+	ID            string    `json:"id"`
+	Role          string    `json:"role"`
+	Username      string    `json:"username"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Phone         string    `json:"phone,omitempty"`
+	Address       string    `json:"address,omitempty"`
+	DateOfBirth   string    `json:"date_of_birth,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// NewProfile returns the profile of user
+func NewProfile(user *User) *Profile {
+	profile := &Profile{
+		ID:            user.ID.String(),
+		Role:          user.Role,
+		Username:      user.Username,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		CreatedAt:     user.CreatedAt,
+	}
+	if user.Phone != nil {
+		profile.Phone = *user.Phone
+	}
+	if user.Address != nil {
+		profile.Address = *user.Address
+	}
+	if user.DateOfBirth != nil {
+		profile.DateOfBirth = user.DateOfBirth.Format(DateLayout)
+	}
+
+	return profile
+}
+
+/*
+UpdateProfileRequest is the body of the update profile route.
+
+Fields left out are not changed, and the phone, address and date of birth
+are removed by setting them to an empty string. The email and password have
+their own routes, since changing them requires the current password.
+*/
+type UpdateProfileRequest struct {
+	FirstName   *string `json:"first_name" validate:"omitnil,min=1,max=50"`
+	LastName    *string `json:"last_name" validate:"omitnil,min=1,max=50"`
+	Phone       *string `json:"phone" validate:"omitnil,eq=|e164"`
+	Address     *string `json:"address" validate:"omitnil,max=255"`
+	DateOfBirth *string `json:"date_of_birth" validate:"omitnil,eq=|datetime=2006-01-02"`
+}
+
+// ChangeEmailRequest is the body of the change email route
+type ChangeEmailRequest struct {
+	Email    string `json:"email" validate:"required,email,max=100"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ChangePasswordRequest is the body of the change password route
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
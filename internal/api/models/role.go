@@ -0,0 +1,91 @@
+package models
+
+import (
+	"github.com/bukharney/bank-core/internal/apperr"
+)
+
+var (
+	ErrPermissionDenied = apperr.Forbidden("permission_denied", "you do not have permission to do this")
+	ErrUnknownRole      = apperr.BadRequest("unknown_role", "unknown role")
+	ErrOwnRole          = apperr.Conflict("own_role", "admins cannot change their own role")
+)
+
+// Roles a user can have
+const (
+	RoleUser       = "user"
+	RoleAdmin      = "admin"
+	RoleATM        = "atm"
+	RoleCompliance = "compliance"
+)
+
+// Permission allows calling a group of routes
+type Permission string
+
+// Permissions granted to the roles
+const (
+	PermAccountsRead         Permission = "accounts:read"
+	PermAccountsCreate       Permission = "accounts:create"
+	PermTransactionsRead     Permission = "transactions:read"
+	PermTransactionsTransfer Permission = "transactions:transfer"
+	PermTransactionsWithdraw Permission = "transactions:withdraw"
+	PermTransactionsDeposit  Permission = "transactions:deposit"
+	PermTransactionsStatus   Permission = "transactions:status"
+	PermLedgerRead           Permission = "ledger:read"
+	PermUsersSessions        Permission = "users:sessions"
+	PermUsersRoles           Permission = "users:roles"
+	PermUsersUnlock          Permission = "users:unlock"
+	PermKYCReview            Permission = "kyc:review"
+	PermMetricsRead          Permission = "metrics:read"
+)
+
+// customerPermissions are the permissions of account holders
+var customerPermissions = []Permission{
+	PermAccountsRead,
+	PermAccountsCreate,
+	PermTransactionsRead,
+	PermTransactionsTransfer,
+	PermTransactionsWithdraw,
+}
+
+/*
+RolePermissions maps every role to its permissions.
+
+Only ATMs deposit money, since a deposit is cash put into a machine.
+Compliance officers only review identity documents. Admins can do
+everything account holders can, and manage the bank.
+*/
+var RolePermissions = map[string][]Permission{
+	RoleUser:       customerPermissions,
+	RoleATM:        {PermTransactionsDeposit},
+	RoleCompliance: {PermKYCReview},
+	RoleAdmin: append([]Permission{
+		PermTransactionsStatus,
+		PermLedgerRead,
+		PermUsersSessions,
+		PermUsersRoles,
+		PermUsersUnlock,
+		PermKYCReview,
+		PermMetricsRead,
+	}, customerPermissions...),
+}
+
+// IsRole reports whether role is a known role
+func IsRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// PermissionsOf returns the permissions of role as strings, for token claims
+func PermissionsOf(role string) []string {
+	permissions := []string{}
+	for _, permission := range RolePermissions[role] {
+		permissions = append(permissions, string(permission))
+	}
+
+	return permissions
+}
+
+// AssignRoleRequest is the request to change the role of a user
+type AssignRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
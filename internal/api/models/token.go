@@ -0,0 +1,44 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+)
+
+// Purposes of user tokens, a token is only accepted for its own purpose
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+)
+
+var (
+	ErrInvalidUserToken = apperr.BadRequest("invalid_token", "the link is invalid, has expired or was already used")
+)
+
+type UserTokenRepository interface {
+	CreateUserToken(ctx context.Context, token *UserToken) error
+	FindUserToken(ctx context.Context, purpose string, tokenHash string) (string, error)
+	ConsumeUserToken(ctx context.Context, purpose string, tokenHash string) (string, error)
+	DeleteUserTokens(ctx context.Context, userId string, purpose string) error
+}
+
+// UserToken is a single-use token emailed to a user. Only its hash is stored.
+type UserToken struct {
+	TokenHash string    `db:"token_hash"`
+	UserID    string    `db:"user_id"`
+	Purpose   string    `db:"purpose"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// ForgotPasswordRequest asks for a password reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ResetPasswordRequest sets a new password with a password reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
@@ -1,63 +1,85 @@
 package models
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bukharney/bank-core/internal/apperr"
+	"github.com/bukharney/bank-core/internal/money"
 	"github.com/jmoiron/sqlx"
 )
 
+var (
+	ErrAccountNotFound     = apperr.NotFound("account_not_found", "account not found")
+	ErrAccountNotOwned     = apperr.Forbidden("account_not_owned", "account does not belong to user")
+	ErrInsufficientFunds   = apperr.InsufficientFunds("insufficient funds")
+	ErrSameAccount         = apperr.New(apperr.KindValidation, "same_account", "cannot transfer to the same account")
+	ErrTransactionNotFound = apperr.NotFound("transaction_not_found", "transaction not found")
+	ErrInvalidCursor       = apperr.BadRequest("invalid_cursor", "invalid cursor")
+	ErrTransactionSettled  = apperr.Conflict("transaction_settled", "transaction is no longer pending")
+)
+
 type TransactionRepository interface {
-	CreateTransaction(tx *sqlx.Tx, transaction *Transaction) error
-	GetTransactionsByUserID(userID string) ([]*Transaction, error)
-	Transfer(fromAccountID int, toAccountID int, amount float64) error
-	Deposit(accountID int, amount float64) error
-	Withdrawal(accountID int, atmId int, amount float64) error
-	UpdateTransactionStatus(id int, status string) error
-	GetTransactionByID(id int) (*Transaction, error)
-	GetTransactionsByAccountID(accountID int) ([]*Transaction, error)
+	CreateTransaction(ctx context.Context, tx *sqlx.Tx, transaction *Transaction) error
+	GetTransactionsByUserID(ctx context.Context, userID string) ([]*Transaction, error)
+	Transfer(ctx context.Context, userID string, fromAccountID int, toAccountID int, amount money.Money) error
+	Deposit(ctx context.Context, accountID int, amount money.Money) error
+	Withdraw(ctx context.Context, userID string, accountID int, atmId int, amount money.Money) (*Transaction, error)
+	ReverseWithdrawal(ctx context.Context, transaction *Transaction) error
+	UpdateTransactionStatus(ctx context.Context, id int, status string) error
+	GetTransactionByID(ctx context.Context, id int) (*Transaction, error)
+	GetTransactionsByAccountID(ctx context.Context, accountID int) ([]*Transaction, error)
+	ListTransactions(ctx context.Context, filter *TransactionFilter) ([]*Transaction, error)
 }
 
 type TransactionUsecase interface {
-	Transfer(req *TransferRequest) error
-	Deposit(req *DepositRequest) error
-	Withdrawal(req *WithdrawalRequest) error
-	UpdateTransactionStatus(req *UpdateTransactionStatusRequest) error
-	GetTransactionByID(id int) (*Transaction, error)
-	GetTransactionsByAccountID(accountID int) ([]*Transaction, error)
+	Transfer(ctx context.Context, req *TransferRequest) error
+	Deposit(ctx context.Context, req *DepositRequest) error
+	Withdrawal(ctx context.Context, req *WithdrawalRequest) error
+	UpdateTransactionStatus(ctx context.Context, req *UpdateTransactionStatusRequest) error
+	GetTransactionByID(ctx context.Context, userID string, id int) (*Transaction, error)
+	GetTransactionsByAccountID(ctx context.Context, accountID int) ([]*Transaction, error)
+	ListTransactions(ctx context.Context, filter *TransactionFilter) (*TransactionPage, error)
 }
 
 type Transaction struct {
-	ID                   int       `json:"id" db:"id"`
-	AccountID            int       `json:"account_id" db:"account_id"`
-	ReceiverAccountID    int       `json:"receiver_account_id" db:"receiver_account_id"`
-	Amount               float64   `json:"amount" db:"amount"`
-	TransactionType      string    `json:"transaction_type" db:"transaction_type"`
-	TransactionReference string    `json:"transaction_reference" db:"transaction_reference"`
-	TransactionStatus    string    `json:"transaction_status" db:"transaction_status"`
-	TransactionDate      time.Time `json:"transaction_date" db:"transaction_date"`
+	ID                   int         `json:"id" db:"id"`
+	AccountID            int         `json:"account_id" db:"account_id"`
+	ReceiverAccountID    *int        `json:"receiver_account_id" db:"receiver_account_id"`
+	Amount               money.Money `json:"amount" db:"amount"`
+	TransactionType      string      `json:"transaction_type" db:"transaction_type"`
+	TransactionReference string      `json:"transaction_reference" db:"transaction_reference"`
+	TransactionStatus    string      `json:"transaction_status" db:"transaction_status"`
+	TransactionDate      time.Time   `json:"transaction_date" db:"transaction_date"`
 }
 
 type TransferRequest struct {
-	UserID        string  `json:"user_id"`
-	FromAccountID int     `json:"from_account_id"`
-	ToAccountID   int     `json:"to_account_id" validate:"required"`
-	Amount        float64 `json:"amount" validate:"required"`
+	UserID        string      `json:"user_id"`
+	FromAccountID int         `json:"from_account_id"`
+	ToAccountID   int         `json:"to_account_id" validate:"required"`
+	Amount        money.Money `json:"amount"`
+	// MFACode is a TOTP or recovery code, required above the step-up amount
+	MFACode string `json:"mfa_code,omitempty"`
 }
 
 type DepositRequest struct {
-	UserID     string  `json:"user_id"`
-	AccountID  int     `json:"account_id" validate:"required"`
-	Amount     float64 `json:"amount" validate:"required"`
-	DepositRef string  `json:"deposit_ref"`
+	UserID     string      `json:"user_id"`
+	AccountID  int         `json:"account_id" validate:"required"`
+	Amount     money.Money `json:"amount"`
+	DepositRef string      `json:"deposit_ref"`
 }
 
 type WithdrawalRequest struct {
-	UserID        string  `json:"user_id"`
-	AccountID     int     `json:"account_id" validate:"required"`
-	Amount        float64 `json:"amount" validate:"required"`
-	ATMID         int     `json:"atm_id" validate:"required"`
-	SessionID     string  `json:"session_id" validate:"required"`
-	WithdrawalRef string  `json:"withdrawal_ref"`
+	UserID        string      `json:"user_id"`
+	AccountID     int         `json:"account_id" validate:"required"`
+	Amount        money.Money `json:"amount"`
+	ATMID         int         `json:"atm_id" validate:"required"`
+	SessionID     string      `json:"session_id" validate:"required"`
+	WithdrawalRef string      `json:"withdrawal_ref"`
 }
 
 type UpdateTransactionStatusRequest struct {
@@ -65,3 +87,69 @@ type UpdateTransactionStatusRequest struct {
 	ID     int    `json:"id" validate:"required"`
 	Status string `json:"status" validate:"required"`
 }
+
+// Transaction types
+const (
+	TransactionTypeTransfer = "transfer"
+	TransactionTypeDeposit  = "deposit"
+	TransactionTypeWithdraw = "withdraw"
+)
+
+// TransactionFilter selects the transaction history of a user.
+// Nil and empty fields are not filtered on.
+type TransactionFilter struct {
+	UserID    string
+	AccountID *int
+	From      *time.Time
+	To        *time.Time
+	Type      string
+	Status    string
+	MinAmount *money.Money
+	MaxAmount *money.Money
+	Cursor    *TransactionCursor
+	Limit     int
+}
+
+// TransactionCursor is the position of the last transaction of a page.
+// History is ordered by date then ID, newest first.
+type TransactionCursor struct {
+	Date time.Time
+	ID   int
+}
+
+// Encode encodes the cursor as an opaque URL-safe string
+func (c *TransactionCursor) Encode() string {
+	raw := fmt.Sprintf("%s|%d", c.Date.Format(time.RFC3339Nano), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseTransactionCursor decodes a cursor produced by Encode
+func ParseTransactionCursor(s string) (*TransactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	date, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &TransactionCursor{}
+	cursor.Date, err = time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor.ID, err = strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+type TransactionPage struct {
+	Transactions []*Transaction `json:"transactions"`
+	NextCursor   string         `json:"next_cursor,omitempty"`
+}
@@ -1,28 +1,92 @@
 package models
 
 import (
+	"context"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/bukharney/bank-core/internal/apperr"
 	"github.com/google/uuid"
 )
 
+var (
+	ErrUserNotFound  = apperr.NotFound("user_not_found", "user not found")
+	ErrEmailTaken    = apperr.Conflict("email_taken", "user with this email already exists")
+	ErrUsernameTaken = apperr.Conflict("username_taken", "user with this username already exists")
+)
+
+// usernamePattern is the format of a normalized username: 3 to 30 lower-case
+// letters, digits, dots, dashes and underscores, starting with a letter or digit
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,29}$`)
+
 type UserUsecase interface {
-	Register(user *User) (int, error)
+	Register(ctx context.Context, req *RegisterRequest) error
+	VerifyEmail(ctx context.Context, token string) error
+	GetProfile(ctx context.Context, userId string) (*Profile, error)
+	UpdateProfile(ctx context.Context, userId string, req *UpdateProfileRequest) (*Profile, error)
+	ChangeEmail(ctx context.Context, userId string, req *ChangeEmailRequest, client *ClientInfo) (*Profile, error)
+	ChangePassword(ctx context.Context, userId string, sessionId string, req *ChangePasswordRequest, client *ClientInfo) error
 }
 
 type UserRepository interface {
-	GetUserByEmail(email string) (*User, error)
-	GetUserById(id string) (*User, error)
-	Register(user *User, account *Account) error
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserById(ctx context.Context, id string) (*User, error)
+	Register(ctx context.Context, user *User) error
+	UpdateRole(ctx context.Context, id string, role string) error
+	UpdatePassword(ctx context.Context, id string, password string) error
+	MarkEmailVerified(ctx context.Context, id string) error
+	UpdateProfile(ctx context.Context, user *User) error
+	UpdateEmail(ctx context.Context, id string, email string) error
 }
 
 type User struct {
 	ID        uuid.UUID `json:"id" db:"id"`
 	Role      string    `json:"role" db:"role"`
-	Username  string    `json:"username" db:"username" validate:"required"`
-	FirstName string    `json:"first_name" db:"first_name" validate:"required"`
-	LastName  string    `json:"last_name" db:"last_name" validate:"required"`
-	Email     string    `json:"email" db:"email" validate:"required"`
-	Password  string    `json:"password" db:"password" validate:"required"`
+	Username  string    `json:"username" db:"username"`
+	FirstName string    `json:"first_name" db:"first_name"`
+	LastName  string    `json:"last_name" db:"last_name"`
+	Email     string    `json:"email" db:"email"`
+	// Password is the hash of the password, never sent to clients
+	Password  string    `json:"-" db:"password"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// EmailVerifiedAt is set once the user follows the verification link
+	EmailVerifiedAt *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	Phone           *string    `json:"phone" db:"phone"`
+	Address         *string    `json:"address" db:"address"`
+	DateOfBirth     *time.Time `json:"date_of_birth" db:"date_of_birth"`
+	UpdatedAt       *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RegisterRequest is the body of the registration route. The role and ID
+// of new users are always chosen by the server.
+type RegisterRequest struct {
+	Username  string `json:"username" validate:"required,username"`
+	FirstName string `json:"first_name" validate:"required,max=50"`
+	LastName  string `json:"last_name" validate:"required,max=50"`
+	Email     string `json:"email" validate:"required,email,max=100"`
+	Password  string `json:"password" validate:"required"`
+}
+
+// Normalize trims the request and normalizes its email and username
+func (r *RegisterRequest) Normalize() {
+	r.Username = NormalizeUsername(r.Username)
+	r.FirstName = strings.TrimSpace(r.FirstName)
+	r.LastName = strings.TrimSpace(r.LastName)
+	r.Email = NormalizeEmail(r.Email)
+}
+
+// NormalizeEmail returns the form emails are stored and looked up in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeUsername returns the form usernames are stored and looked up in
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// IsUsername reports whether username is a valid normalized username
+func IsUsername(username string) bool {
+	return usernamePattern.MatchString(username)
 }
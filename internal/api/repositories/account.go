@@ -1,6 +1,10 @@
 package repositories
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+
 	"github.com/bukharney/bank-core/internal/api/models"
 	"github.com/bukharney/bank-core/internal/config"
 	"github.com/jmoiron/sqlx"
@@ -24,8 +28,8 @@ func NewAccountRepository(pg *sqlx.DB, rdb *redis.Client, cfg *config.Config) *A
 }
 
 // CreateAccount creates a new account
-func (r *AccountRepository) CreateAccount(account *models.CreateAccountRequest) error {
-	_, err := r.Db.NamedExec(`INSERT INTO accounts (user_id, balance, account_type)
+func (r *AccountRepository) CreateAccount(ctx context.Context, account *models.CreateAccountRequest) error {
+	_, err := r.Db.NamedExecContext(ctx, `INSERT INTO accounts (user_id, balance, account_type)
 	VALUES (:user_id, :balance, :account_type)`, account)
 	if err != nil {
 		return err
@@ -35,10 +39,13 @@ func (r *AccountRepository) CreateAccount(account *models.CreateAccountRequest)
 }
 
 // GetAccountByID gets an account by ID
-func (r *AccountRepository) GetAccountByID(accountID int) (*models.Account, error) {
+func (r *AccountRepository) GetAccountByID(ctx context.Context, accountID int) (*models.Account, error) {
 	account := &models.Account{}
-	err := r.Db.Get(account, "SELECT * FROM accounts WHERE id = $1", accountID)
+	err := r.Db.GetContext(ctx, account, "SELECT * FROM accounts WHERE id = $1", accountID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrAccountNotFound
+		}
 		return nil, err
 	}
 
@@ -46,9 +53,9 @@ func (r *AccountRepository) GetAccountByID(accountID int) (*models.Account, erro
 }
 
 // GetAccount gets an account by user ID
-func (r *AccountRepository) GetAccountsByUserID(userID string) (*[]models.Account, error) {
+func (r *AccountRepository) GetAccountsByUserID(ctx context.Context, userID string) (*[]models.Account, error) {
 	accounts := &[]models.Account{}
-	err := r.Db.Select(accounts, "SELECT * FROM accounts WHERE user_id = $1", userID)
+	err := r.Db.SelectContext(ctx, accounts, "SELECT * FROM accounts WHERE user_id = $1", userID)
 	if err != nil {
 		return nil, err
 	}
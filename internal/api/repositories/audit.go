@@ -0,0 +1,40 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// AuditRepository is the repository for the audit trail
+type AuditRepository struct {
+	Cfg *config.Config
+	Db  *sqlx.DB
+	Rdb *redis.Client
+}
+
+// NewAuditRepository creates a new AuditRepository
+func NewAuditRepository(db *sqlx.DB, rdb *redis.Client, cfg *config.Config) models.AuditRepository {
+	return &AuditRepository{
+		Db:  db,
+		Rdb: rdb,
+		Cfg: cfg,
+	}
+}
+
+// RecordEvent appends an event to the audit trail
+func (r *AuditRepository) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	return r.Db.QueryRowxContext(ctx, `INSERT INTO audit_events (event_type, user_id, actor_id, ip, details)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		event.Type, event.UserID, event.ActorID, event.IP, details,
+	).Scan(&event.ID, &event.CreatedAt)
+}
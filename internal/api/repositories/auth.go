@@ -2,6 +2,10 @@ package repositories
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
 
 	"github.com/bukharney/bank-core/internal/api/models"
 	"github.com/bukharney/bank-core/internal/config"
@@ -25,12 +29,174 @@ func NewAuthRepository(db *sqlx.DB, rdb *redis.Client, cfg *config.Config) model
 	}
 }
 
-// UpdateRefreshToken updates the refresh token
-func (r *AuthRepository) UpdateRefreshToken(userId string, refreshToken string) error {
-	_, err := r.Rdb.Set(context.Background(), userId, refreshToken, 0).Result()
+// storedSession is how a session is stored in redis, including the ID of
+// its current refresh token which is never sent to clients
+type storedSession struct {
+	*models.Session
+	TokenID string `json:"token_id"`
+}
+
+// sessionKey is the redis key of a session
+func sessionKey(sessionId string) string {
+	return "session:" + sessionId
+}
+
+// revokedSessionKey is the redis key marking a session as revoked
+func revokedSessionKey(sessionId string) string {
+	return "revoked_session:" + sessionId
+}
+
+// userSessionsKey is the redis key of the set of session IDs of a user
+func userSessionsKey(userId string) string {
+	return "user_sessions:" + userId
+}
+
+// encodeSession encodes a session for redis
+func encodeSession(session *models.Session) ([]byte, error) {
+	return json.Marshal(storedSession{Session: session, TokenID: session.TokenID})
+}
+
+// readSession reads the session stored at key
+func readSession(ctx context.Context, rdb redis.Cmdable, key string) (*models.Session, error) {
+	data, err := rdb.Get(ctx, key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, models.ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	stored := storedSession{Session: &models.Session{}}
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return nil, err
+	}
+
+	stored.Session.TokenID = stored.TokenID
+	return stored.Session, nil
+}
+
+// writeSession queues the commands that store session until it expires.
+// The set of the user's sessions lives as long as their newest session.
+func writeSession(ctx context.Context, pipe redis.Pipeliner, session *models.Session) error {
+	data, err := encodeSession(session)
 	if err != nil {
 		return err
 	}
 
+	ttl := time.Until(session.ExpiresAt)
+	pipe.Set(ctx, sessionKey(session.ID), data, ttl)
+	pipe.SAdd(ctx, userSessionsKey(session.UserID), session.ID)
+	pipe.Expire(ctx, userSessionsKey(session.UserID), ttl)
 	return nil
 }
+
+// CreateSession stores a new session
+func (r *AuthRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	_, err := r.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return writeSession(ctx, pipe, session)
+	})
+
+	return err
+}
+
+// GetSession gets a session by its ID
+func (r *AuthRepository) GetSession(ctx context.Context, sessionId string) (*models.Session, error) {
+	return readSession(ctx, r.Rdb, sessionKey(sessionId))
+}
+
+/*
+RotateSession replaces the stored session with session, provided the stored
+session's current refresh token is still usedTokenId.
+
+The check and the write are atomic, so if the same refresh token is used
+twice concurrently only one of the requests can rotate it and the other
+gets models.ErrRefreshTokenReused.
+*/
+func (r *AuthRepository) RotateSession(ctx context.Context, session *models.Session, usedTokenId string) error {
+	key := sessionKey(session.ID)
+	err := r.Rdb.Watch(ctx, func(tx *redis.Tx) error {
+		stored, err := readSession(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if stored.TokenID != usedTokenId {
+			return models.ErrRefreshTokenReused
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return writeSession(ctx, pipe, session)
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return models.ErrRefreshTokenReused
+	}
+
+	return err
+}
+
+// ListSessions lists the active sessions of a user, most recently used first
+func (r *AuthRepository) ListSessions(ctx context.Context, userId string) ([]*models.Session, error) {
+	sessionIds, err := r.Rdb.SMembers(ctx, userSessionsKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*models.Session{}
+	expired := []interface{}{}
+	for _, sessionId := range sessionIds {
+		session, err := readSession(ctx, r.Rdb, sessionKey(sessionId))
+		if err != nil {
+			if errors.Is(err, models.ErrSessionNotFound) {
+				expired = append(expired, sessionId)
+				continue
+			}
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	// Sessions expire on their own, so drop them from the set lazily
+	if len(expired) > 0 {
+		err = r.Rdb.SRem(ctx, userSessionsKey(userId), expired...).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+/*
+DeleteSession deletes a session, revoking every refresh token issued for it.
+
+The session is also put on the access token denylist until the access tokens
+issued for it have expired, so they stop working immediately.
+*/
+func (r *AuthRepository) DeleteSession(ctx context.Context, session *models.Session) error {
+	_, err := r.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionKey(session.ID))
+		pipe.SRem(ctx, userSessionsKey(session.UserID), session.ID)
+		pipe.Set(ctx, revokedSessionKey(session.ID), 1, r.Cfg.JWT.AccessTTL)
+		return nil
+	})
+
+	return err
+}
+
+// IsSessionRevoked reports whether the access tokens of a session have been revoked
+func (r *AuthRepository) IsSessionRevoked(ctx context.Context, sessionId string) (bool, error) {
+	n, err := r.Rdb.Exists(ctx, revokedSessionKey(sessionId)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
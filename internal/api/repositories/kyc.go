@@ -0,0 +1,155 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// KYCRepository is the repository for identity verification
+type KYCRepository struct {
+	Cfg *config.Config
+	Db  *sqlx.DB
+	Rdb *redis.Client
+}
+
+// NewKYCRepository creates a new KYCRepository
+func NewKYCRepository(db *sqlx.DB, rdb *redis.Client, cfg *config.Config) models.KYCRepository {
+	return &KYCRepository{
+		Db:  db,
+		Rdb: rdb,
+		Cfg: cfg,
+	}
+}
+
+// GetKYC gets the verification of a user, without its documents
+func (r *KYCRepository) GetKYC(ctx context.Context, userId string) (*models.KYC, error) {
+	kyc := &models.KYC{}
+	err := r.Db.GetContext(ctx, kyc, "SELECT * FROM user_kyc WHERE user_id = $1", userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrKYCNotFound
+		}
+		return nil, err
+	}
+
+	return kyc, nil
+}
+
+// ListKYC lists up to limit verifications in a state, the longest waiting first
+func (r *KYCRepository) ListKYC(ctx context.Context, status string, limit int) ([]*models.KYC, error) {
+	kycs := []*models.KYC{}
+	err := r.Db.SelectContext(ctx, &kycs, `SELECT * FROM user_kyc WHERE status = $1
+	ORDER BY submitted_at ASC NULLS LAST, updated_at ASC LIMIT $2`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return kycs, nil
+}
+
+// UpdateKYCStatus saves the state of a verification if it is still in the
+// state from, so concurrent reviews cannot both decide on a submission
+func (r *KYCRepository) UpdateKYCStatus(ctx context.Context, kyc *models.KYC, from string) error {
+	err := r.Db.GetContext(ctx, &kyc.UpdatedAt, `UPDATE user_kyc
+	SET status = $1, submitted_at = $2, reviewed_at = $3, reviewer_id = $4, rejection_reason = $5, updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = $6 AND status = $7
+	RETURNING updated_at`,
+		kyc.Status, kyc.SubmittedAt, kyc.ReviewedAt, kyc.ReviewerID, kyc.RejectionReason, kyc.UserID, from,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrKYCInvalidTransition
+		}
+		return err
+	}
+
+	return nil
+}
+
+// GetDocuments gets the documents a user uploaded
+func (r *KYCRepository) GetDocuments(ctx context.Context, userId string) ([]*models.KYCDocument, error) {
+	documents := []*models.KYCDocument{}
+	err := r.Db.SelectContext(ctx, &documents, "SELECT * FROM kyc_documents WHERE user_id = $1 ORDER BY uploaded_at", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// GetDocument gets a document by ID
+func (r *KYCRepository) GetDocument(ctx context.Context, id string) (*models.KYCDocument, error) {
+	document := &models.KYCDocument{}
+	err := r.Db.GetContext(ctx, document, "SELECT * FROM kyc_documents WHERE id = $1", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrDocumentNotFound
+		}
+		return nil, err
+	}
+
+	return document, nil
+}
+
+/*
+SaveDocument saves a document, replacing the user's document of the same type.
+It returns the blob key of the replaced document, or an empty string.
+
+The verification is locked while the document is saved, so a document cannot
+be changed after the verification was submitted.
+*/
+func (r *KYCRepository) SaveDocument(ctx context.Context, document *models.KYCDocument) (string, error) {
+	tx, err := r.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	kyc := &models.KYC{}
+	err = tx.GetContext(ctx, kyc, "SELECT * FROM user_kyc WHERE user_id = $1 FOR UPDATE", document.UserID)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrKYCNotFound
+		}
+		return "", err
+	}
+
+	if !kyc.CanUpload() {
+		tx.Rollback()
+		return "", models.ErrKYCDocumentsLocked
+	}
+
+	var replaced string
+	err = tx.GetContext(ctx, &replaced, "SELECT blob_key FROM kyc_documents WHERE user_id = $1 AND document_type = $2",
+		document.UserID, document.Type)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return "", err
+	}
+
+	err = tx.GetContext(ctx, &document.UploadedAt, `INSERT INTO kyc_documents (id, user_id, document_type, blob_key, content_type, size_bytes)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (user_id, document_type) DO UPDATE
+	SET id = EXCLUDED.id, blob_key = EXCLUDED.blob_key, content_type = EXCLUDED.content_type,
+		size_bytes = EXCLUDED.size_bytes, uploaded_at = CURRENT_TIMESTAMP
+	RETURNING uploaded_at`,
+		document.ID, document.UserID, document.Type, document.BlobKey, document.ContentType, document.Size,
+	)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return replaced, nil
+}
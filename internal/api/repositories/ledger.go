@@ -0,0 +1,161 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// LedgerRepository is the repository for the general ledger
+type LedgerRepository struct {
+	Db  *sqlx.DB
+	Rdb *redis.Client
+	Cfg *config.Config
+}
+
+// NewLedgerRepository creates a new LedgerRepository
+func NewLedgerRepository(pg *sqlx.DB, rdb *redis.Client, cfg *config.Config) *LedgerRepository {
+	return &LedgerRepository{
+		Db:  pg,
+		Rdb: rdb,
+		Cfg: cfg,
+	}
+}
+
+// Post writes a balanced journal entry inside tx and checks that the cached
+// balance of every customer account it touches still matches the ledger
+func (r *LedgerRepository) Post(ctx context.Context, tx *sqlx.Tx, entry *models.JournalEntry) error {
+	if len(entry.Lines) < 2 {
+		return errors.New("journal entry needs at least two lines")
+	}
+
+	sum := money.FromMinor(0)
+	for _, line := range entry.Lines {
+		if line.Amount.IsZero() {
+			return errors.New("journal line amount must not be zero")
+		}
+
+		var err error
+		sum, err = sum.Add(line.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !sum.IsZero() {
+		return fmt.Errorf("journal entry is not balanced: off by %s", sum)
+	}
+
+	err := tx.GetContext(ctx, &entry.ID, `INSERT INTO journal_entries (transaction_id, description)
+	VALUES ($1, $2) RETURNING id`, entry.TransactionID, entry.Description)
+	if err != nil {
+		return err
+	}
+
+	for i := range entry.Lines {
+		line := &entry.Lines[i]
+		line.EntryID = entry.ID
+
+		err = tx.GetContext(ctx, &line.ID, `INSERT INTO journal_lines (entry_id, ledger_account_id, amount)
+		VALUES ($1, $2, $3) RETURNING id`, line.EntryID, line.LedgerAccountID, line.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, line := range entry.Lines {
+		err = r.checkCustomerBalance(ctx, tx, line.LedgerAccountID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkCustomerBalance compares accounts.balance with the balance derived from
+// the journal. Customer accounts are liabilities, so their balance is the
+// negated sum of their lines. System ledger accounts are skipped.
+func (r *LedgerRepository) checkCustomerBalance(ctx context.Context, tx *sqlx.Tx, ledgerAccountID int) error {
+	row := struct {
+		AccountID     int         `db:"account_id"`
+		Balance       money.Money `db:"balance"`
+		LedgerBalance money.Money `db:"ledger_balance"`
+	}{}
+
+	err := tx.GetContext(ctx, &row, `SELECT a.id AS account_id, a.balance, COALESCE(-SUM(l.amount), 0) AS ledger_balance
+	FROM ledger_accounts la
+	JOIN accounts a ON a.id = la.account_id
+	LEFT JOIN journal_lines l ON l.ledger_account_id = la.id
+	WHERE la.id = $1
+	GROUP BY a.id, a.balance`, ledgerAccountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if row.Balance.Cmp(row.LedgerBalance) != 0 {
+		return fmt.Errorf("ledger mismatch for account %d: balance %s, ledger %s", row.AccountID, row.Balance, row.LedgerBalance)
+	}
+
+	return nil
+}
+
+// GetCustomerLedgerAccountID gets the ledger account of a customer account,
+// creating it on first use
+func (r *LedgerRepository) GetCustomerLedgerAccountID(ctx context.Context, tx *sqlx.Tx, accountID int) (int, error) {
+	_, err := tx.ExecContext(ctx, `INSERT INTO ledger_accounts (code, name, ledger_type, account_id)
+	VALUES ($1, $2, 'liability', $3) ON CONFLICT (account_id) DO NOTHING`,
+		models.CustomerLedgerCode(accountID), fmt.Sprintf("Customer account %d", accountID), accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = tx.GetContext(ctx, &id, "SELECT id FROM ledger_accounts WHERE account_id = $1", accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetSystemLedgerAccountID gets a system ledger account by its code
+func (r *LedgerRepository) GetSystemLedgerAccountID(ctx context.Context, tx *sqlx.Tx, code string) (int, error) {
+	var id int
+	err := tx.GetContext(ctx, &id, "SELECT id FROM ledger_accounts WHERE code = $1 AND account_id IS NULL", code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("ledger account %s not found", code)
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetTrialBalance gets the debit and credit totals of every ledger account
+func (r *LedgerRepository) GetTrialBalance(ctx context.Context) ([]*models.TrialBalanceLine, error) {
+	lines := []*models.TrialBalanceLine{}
+	err := r.Db.SelectContext(ctx, &lines, `SELECT la.id, la.code, la.name, la.ledger_type,
+		COALESCE(SUM(l.amount) FILTER (WHERE l.amount > 0), 0) AS debit,
+		COALESCE(-SUM(l.amount) FILTER (WHERE l.amount < 0), 0) AS credit
+	FROM ledger_accounts la
+	LEFT JOIN journal_lines l ON l.ledger_account_id = la.id
+	GROUP BY la.id, la.code, la.name, la.ledger_type
+	ORDER BY la.code`)
+	if err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,110 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// loginBlockLocked is the value of a login block key that is a lockout
+const loginBlockLocked = "locked"
+
+// LockoutRepository is the repository for failed login counters and lockouts
+type LockoutRepository struct {
+	Cfg *config.Config
+	Db  *sqlx.DB
+	Rdb *redis.Client
+}
+
+// NewLockoutRepository creates a new LockoutRepository
+func NewLockoutRepository(db *sqlx.DB, rdb *redis.Client, cfg *config.Config) models.LockoutRepository {
+	return &LockoutRepository{
+		Db:  db,
+		Rdb: rdb,
+		Cfg: cfg,
+	}
+}
+
+// loginFailuresKey is the redis key counting the failed logins of a subject
+func loginFailuresKey(scope string, subject string) string {
+	return "login_failures:" + scope + ":" + subject
+}
+
+// loginBlockKey is the redis key that blocks the logins of a subject
+func loginBlockKey(scope string, subject string) string {
+	return "login_block:" + scope + ":" + subject
+}
+
+// GetLoginBlock gets the block on the logins of a subject, or nil if there is none
+func (r *LockoutRepository) GetLoginBlock(ctx context.Context, scope string, subject string) (*models.LoginBlock, error) {
+	key := loginBlockKey(scope, subject)
+
+	var value *redis.StringCmd
+	var ttl *redis.DurationCmd
+	_, err := r.Rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		value = pipe.Get(ctx, key)
+		ttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// The key expired between the two commands
+	if ttl.Val() <= 0 {
+		return nil, nil
+	}
+
+	return &models.LoginBlock{
+		Locked:     value.Val() == loginBlockLocked,
+		RetryAfter: ttl.Val(),
+	}, nil
+}
+
+// recordLoginFailureScript counts a failure and starts the window on the
+// first one. The counter and its expiry are set atomically, and a counter
+// left without one is given one, so a subject is never locked out for good.
+var recordLoginFailureScript = redis.NewScript(`
+local failures = redis.call("INCR", KEYS[1])
+if redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return failures
+`)
+
+// RecordLoginFailure counts a failed login of a subject and returns the
+// number of failures within the window, which starts at the first failure
+func (r *LockoutRepository) RecordLoginFailure(ctx context.Context, scope string, subject string, window time.Duration) (int64, error) {
+	key := loginFailuresKey(scope, subject)
+
+	return recordLoginFailureScript.Run(ctx, r.Rdb, []string{key}, window.Milliseconds()).Int64()
+}
+
+// BlockLogin blocks the logins of a subject. A lockout replaces any delay,
+// but a delay never shortens a lockout.
+func (r *LockoutRepository) BlockLogin(ctx context.Context, scope string, subject string, block *models.LoginBlock) error {
+	key := loginBlockKey(scope, subject)
+	if block.Locked {
+		return r.Rdb.Set(ctx, key, loginBlockLocked, block.RetryAfter).Err()
+	}
+
+	return r.Rdb.SetNX(ctx, key, "delayed", block.RetryAfter).Err()
+}
+
+// ClearLoginFailures resets the failed login counter of a subject
+func (r *LockoutRepository) ClearLoginFailures(ctx context.Context, scope string, subject string) error {
+	return r.Rdb.Del(ctx, loginFailuresKey(scope, subject)).Err()
+}
+
+// UnblockLogin lifts any block on the logins of a subject and resets its counter
+func (r *LockoutRepository) UnblockLogin(ctx context.Context, scope string, subject string) error {
+	return r.Rdb.Del(ctx, loginBlockKey(scope, subject), loginFailuresKey(scope, subject)).Err()
+}
@@ -0,0 +1,212 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// MFARepository is the repository for multi-factor authentication
+type MFARepository struct {
+	Cfg *config.Config
+	Db  *sqlx.DB
+	Rdb *redis.Client
+}
+
+// NewMFARepository creates a new MFARepository
+func NewMFARepository(db *sqlx.DB, rdb *redis.Client, cfg *config.Config) models.MFARepository {
+	return &MFARepository{
+		Db:  db,
+		Rdb: rdb,
+		Cfg: cfg,
+	}
+}
+
+// mfaChallengeKey is the redis key of a login MFA challenge
+func mfaChallengeKey(token string) string {
+	return "mfa_challenge:" + token
+}
+
+// GetMFA gets the MFA enrollment of a user
+func (r *MFARepository) GetMFA(ctx context.Context, userId string) (*models.MFA, error) {
+	mfa := &models.MFA{}
+	err := r.Db.GetContext(ctx, mfa, "SELECT * FROM user_mfa WHERE user_id = $1", userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrMFANotEnrolled
+		}
+		return nil, err
+	}
+
+	return mfa, nil
+}
+
+// SaveMFASecret starts or restarts the enrollment of a user with a new
+// secret, unless MFA is already enabled
+func (r *MFARepository) SaveMFASecret(ctx context.Context, userId string, secret string) error {
+	result, err := r.Db.ExecContext(ctx, `INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = CURRENT_TIMESTAMP
+	WHERE user_mfa.enabled = FALSE`, userId, secret)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return models.ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// EnableMFA enables MFA for a user and replaces their recovery codes
+func (r *MFARepository) EnableMFA(ctx context.Context, userId string, codeHashes []string) error {
+	tx, err := r.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE user_mfa SET enabled = TRUE, enabled_at = CURRENT_TIMESTAMP WHERE user_id = $1", userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userId, codeHash)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableMFA removes the MFA enrollment and recovery codes of a user
+func (r *MFARepository) DisableMFA(ctx context.Context, userId string) error {
+	tx, err := r.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_mfa WHERE user_id = $1", userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseMFAStep records that a code of the time step was accepted. It returns
+// false if a code of that step or a later one was already used.
+func (r *MFARepository) UseMFAStep(ctx context.Context, userId string, step int64) (bool, error) {
+	result, err := r.Db.ExecContext(ctx, "UPDATE user_mfa SET last_step = $1 WHERE user_id = $2 AND last_step < $1", step, userId)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// GetRecoveryCodes gets the unused recovery codes of a user
+func (r *MFARepository) GetRecoveryCodes(ctx context.Context, userId string) ([]*models.RecoveryCode, error) {
+	codes := []*models.RecoveryCode{}
+	err := r.Db.SelectContext(ctx, &codes, "SELECT id, code_hash FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks a recovery code as used. It returns false if the
+// code was used concurrently.
+func (r *MFARepository) UseRecoveryCode(ctx context.Context, id int) (bool, error) {
+	result, err := r.Db.ExecContext(ctx, "UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE id = $1 AND used_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// CreateMFAChallenge stores a login MFA challenge for the user until ttl
+func (r *MFARepository) CreateMFAChallenge(ctx context.Context, token string, userId string, ttl time.Duration) error {
+	_, err := r.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, mfaChallengeKey(token), "user_id", userId, "attempts", 0)
+		pipe.Expire(ctx, mfaChallengeKey(token), ttl)
+		return nil
+	})
+
+	return err
+}
+
+// attemptMFAChallengeScript counts an attempt at a challenge and returns its
+// user and attempts. It returns nil if the challenge does not exist, so an
+// expired or guessed token never creates a key.
+var attemptMFAChallengeScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+return {redis.call("HGET", KEYS[1], "user_id"), attempts}
+`)
+
+// AttemptMFAChallenge counts an attempt at a challenge and returns its user
+// and the number of attempts so far, including this one
+func (r *MFARepository) AttemptMFAChallenge(ctx context.Context, token string) (string, int64, error) {
+	result, err := attemptMFAChallengeScript.Run(ctx, r.Rdb, []string{mfaChallengeKey(token)}).Slice()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", 0, models.ErrInvalidMFAChallenge
+		}
+		return "", 0, err
+	}
+
+	userId, _ := result[0].(string)
+	attempts, _ := result[1].(int64)
+	if userId == "" {
+		return "", 0, models.ErrInvalidMFAChallenge
+	}
+
+	return userId, attempts, nil
+}
+
+// DeleteMFAChallenge deletes a login MFA challenge
+func (r *MFARepository) DeleteMFAChallenge(ctx context.Context, token string) error {
+	return r.Rdb.Del(ctx, mfaChallengeKey(token)).Err()
+}
@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/google/uuid"
+)
+
+func TestUseMFAStep(t *testing.T) {
+	pg := testDB(t)
+	ctx := context.Background()
+	repo := NewMFARepository(pg, nil, &config.Config{})
+
+	userID := uuid.NewString()
+	name := "t" + strings.ReplaceAll(userID, "-", "")[:20]
+	_, err := pg.ExecContext(ctx, `INSERT INTO users (id, username, email, password) VALUES ($1, $2, $3, 'x')`,
+		userID, name, name+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.SaveMFASecret(ctx, userID, "sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		step int64
+		ok   bool
+	}{
+		{100, true},
+		// The same step again is a replayed code
+		{100, false},
+		{99, false},
+		{101, true},
+		{100, false},
+	}
+
+	for _, s := range steps {
+		ok, err := repo.UseMFAStep(ctx, userID, s.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != s.ok {
+			t.Errorf("step %d: got %v, want %v", s.step, ok, s.ok)
+		}
+	}
+
+	ok, err := repo.UseMFAStep(ctx, uuid.NewString(), 200)
+	if err != nil || ok {
+		t.Errorf("user without MFA: got %v, %v", ok, err)
+	}
+}
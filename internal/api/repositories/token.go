@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// UserTokenRepository is the repository for single-use user tokens
+type UserTokenRepository struct {
+	Cfg *config.Config
+	Db  *sqlx.DB
+	Rdb *redis.Client
+}
+
+// NewUserTokenRepository creates a new UserTokenRepository
+func NewUserTokenRepository(db *sqlx.DB, rdb *redis.Client, cfg *config.Config) models.UserTokenRepository {
+	return &UserTokenRepository{
+		Db:  db,
+		Rdb: rdb,
+		Cfg: cfg,
+	}
+}
+
+// CreateUserToken stores a new user token
+func (r *UserTokenRepository) CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	_, err := r.Db.NamedExecContext(ctx, `INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at)
+	VALUES (:token_hash, :user_id, :purpose, :expires_at)`, token)
+
+	return err
+}
+
+// FindUserToken returns the user an unused, unexpired token of the purpose
+// belongs to, without using the token up
+func (r *UserTokenRepository) FindUserToken(ctx context.Context, purpose string, tokenHash string) (string, error) {
+	var userId string
+	err := r.Db.GetContext(ctx, &userId, `SELECT user_id FROM user_tokens
+	WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`, tokenHash, purpose)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrInvalidUserToken
+		}
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// ConsumeUserToken marks an unused, unexpired token of the purpose as used
+// and returns the user it belongs to. A token can only be consumed once,
+// even by concurrent requests.
+func (r *UserTokenRepository) ConsumeUserToken(ctx context.Context, purpose string, tokenHash string) (string, error) {
+	var userId string
+	err := r.Db.GetContext(ctx, &userId, `UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP
+	WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	RETURNING user_id`, tokenHash, purpose)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrInvalidUserToken
+		}
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// DeleteUserTokens deletes every token of a user for the purpose, so links
+// sent earlier stop working
+func (r *UserTokenRepository) DeleteUserTokens(ctx context.Context, userId string, purpose string) error {
+	_, err := r.Db.ExecContext(ctx, "DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2", userId, purpose)
+	return err
+}
@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/db"
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/bukharney/bank-core/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newInMemoryTracer installs a tracer provider that records every span
+// synchronously into the returned exporter, until the test ends
+func newInMemoryTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSyncer(exporter),
+	)
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+
+	return exporter
+}
+
+// findSpan returns the first recorded span named name
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	t.Fatalf("no %s span was recorded", name)
+	return tracetest.SpanStub{}
+}
+
+func TestTransferSpans(t *testing.T) {
+	pg := testDB(t)
+	repo := newTestTransactionRepository(pg)
+	userID, ids := createTestAccounts(t, pg, repo, "10.00", "0.00")
+
+	// The queries are traced by the instrumented driver of db.Connect
+	traced, err := db.Connect(context.Background(), &config.Config{DB: config.DBConfig{URL: os.Getenv(testDBEnv)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { traced.Close() })
+	repo = newTestTransactionRepository(traced)
+
+	exporter := newInMemoryTracer(t)
+
+	ctx, root := tracing.Start(context.Background(), "test")
+	err = repo.Transfer(ctx, userID, ids[0], ids[1], money.MustParse("4.00"))
+	root.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	rootSpan := findSpan(t, spans, "test")
+	transfer := findSpan(t, spans, "TransactionRepository.Transfer")
+
+	if transfer.Parent.SpanID() != rootSpan.SpanContext.SpanID() {
+		t.Errorf("transfer span is not a child of the caller's span")
+	}
+	if transfer.Status.Code == codes.Error {
+		t.Errorf("transfer span has error status: %s", transfer.Status.Description)
+	}
+
+	attrs := attribute.NewSet(transfer.Attributes...)
+	from, _ := attrs.Value("account.from")
+	to, _ := attrs.Value("account.to")
+	if from.AsInt64() != int64(ids[0]) || to.AsInt64() != int64(ids[1]) {
+		t.Errorf("transfer span has accounts %d -> %d, want %d -> %d", from.AsInt64(), to.AsInt64(), ids[0], ids[1])
+	}
+
+	// The queries of the transfer are children of its span
+	queries := 0
+	for _, span := range spans {
+		if span.Parent.SpanID() == transfer.SpanContext.SpanID() {
+			queries++
+		}
+	}
+	if queries == 0 {
+		t.Errorf("no query spans were recorded under the transfer span")
+	}
+
+	exporter.Reset()
+
+	err = repo.Transfer(context.Background(), userID, ids[0], ids[1], money.MustParse("100.00"))
+	if !errors.Is(err, models.ErrInsufficientFunds) {
+		t.Fatalf("got %v, want %v", err, models.ErrInsufficientFunds)
+	}
+
+	failed := findSpan(t, exporter.GetSpans(), "TransactionRepository.Transfer")
+	if failed.Status.Code != codes.Error {
+		t.Errorf("failed transfer span has status %v, want %v", failed.Status.Code, codes.Error)
+	}
+	if len(failed.Events) == 0 {
+		t.Errorf("failed transfer span recorded no error event")
+	}
+}
@@ -1,35 +1,53 @@
 package repositories
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/bukharney/bank-core/internal/api/models"
 	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/bukharney/bank-core/internal/tracing"
 	"github.com/bukharney/bank-core/internal/utils"
 	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // TransactionRepository is the repository for the transaction routes
 type TransactionRepository struct {
-	Db  *sqlx.DB
-	Rdb *redis.Client
-	Cfg *config.Config
+	Db     *sqlx.DB
+	Rdb    *redis.Client
+	Cfg    *config.Config
+	Ledger models.LedgerRepository
 }
 
 // NewTransactionRepository creates a new TransactionRepository
-func NewTransactionRepository(pg *sqlx.DB, rdb *redis.Client, cfg *config.Config) *TransactionRepository {
+func NewTransactionRepository(pg *sqlx.DB, rdb *redis.Client, cfg *config.Config, ledger models.LedgerRepository) *TransactionRepository {
 	return &TransactionRepository{
-		Db:  pg,
-		Rdb: rdb,
-		Cfg: cfg,
+		Db:     pg,
+		Rdb:    rdb,
+		Cfg:    cfg,
+		Ledger: ledger,
 	}
 }
 
-// CreateTransaction creates a new transaction
-func (r *TransactionRepository) CreateTransaction(tx *sqlx.Tx, transaction *models.Transaction) error {
-	_, err := tx.NamedExec(`INSERT INTO transactions (account_id, receiver_account_id, amount, transaction_type, transaction_reference, transaction_status)
-	VALUES (:account_id, :receiver_account_id, :amount, :transaction_type, :transaction_reference, :transaction_status)`, transaction)
+// CreateTransaction creates a new transaction and sets its ID
+func (r *TransactionRepository) CreateTransaction(ctx context.Context, tx *sqlx.Tx, transaction *models.Transaction) error {
+	stmt, err := tx.PrepareNamedContext(ctx, `INSERT INTO transactions (account_id, receiver_account_id, amount, transaction_type, transaction_reference, transaction_status)
+	VALUES (:account_id, :receiver_account_id, :amount, :transaction_type, :transaction_reference, :transaction_status)
+	RETURNING id`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.GetContext(ctx, &transaction.ID, transaction)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -38,9 +56,18 @@ func (r *TransactionRepository) CreateTransaction(tx *sqlx.Tx, transaction *mode
 	return nil
 }
 
+// postJournal records the balanced journal entry of a transaction
+func (r *TransactionRepository) postJournal(ctx context.Context, tx *sqlx.Tx, transaction *models.Transaction, lines ...models.JournalLine) error {
+	return r.Ledger.Post(ctx, tx, &models.JournalEntry{
+		TransactionID: &transaction.ID,
+		Description:   fmt.Sprintf("%s %s", transaction.TransactionType, transaction.TransactionReference),
+		Lines:         lines,
+	})
+}
+
 // UpdateTransactionStatus updates the status of a transaction
-func (r *TransactionRepository) UpdateTransactionStatus(id int, status string) error {
-	res, err := r.Db.Exec("UPDATE transactions SET transaction_status = $1 WHERE id = $2", status, id)
+func (r *TransactionRepository) UpdateTransactionStatus(ctx context.Context, id int, status string) error {
+	res, err := r.Db.ExecContext(ctx, "UPDATE transactions SET transaction_status = $1 WHERE id = $2", status, id)
 	if err != nil {
 		return err
 	}
@@ -51,16 +78,78 @@ func (r *TransactionRepository) UpdateTransactionStatus(id int, status string) e
 	}
 
 	if rowsAffected == 0 {
-		return errors.New("transaction not found")
+		return models.ErrTransactionNotFound
 	}
 
 	return nil
 }
 
-// GetTransactionsByAccountID gets transactions by account ID
-func (r *TransactionRepository) GetTransactionsByAccountID(accountID int) ([]*models.Transaction, error) {
+// GetTransactionsByAccountID gets outgoing and incoming transactions of an account
+func (r *TransactionRepository) GetTransactionsByAccountID(ctx context.Context, accountID int) ([]*models.Transaction, error) {
+	return r.ListTransactions(ctx, &models.TransactionFilter{AccountID: &accountID})
+}
+
+/*
+ListTransactions gets the transaction history matching the filter,
+newest first, using keyset pagination on (transaction_date, id).
+
+A transaction belongs to an account when it was sent from it, or when it is a
+transfer received by it. Withdrawals store the ATM in receiver_account_id,
+so they only count on the sending side.
+*/
+func (r *TransactionRepository) ListTransactions(ctx context.Context, filter *models.TransactionFilter) ([]*models.Transaction, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.AccountID != nil {
+		p := arg(*filter.AccountID)
+		conditions = append(conditions, fmt.Sprintf(
+			"(account_id = %s OR (receiver_account_id = %s AND transaction_type = 'transfer'))", p, p))
+	}
+	if filter.UserID != "" {
+		p := arg(filter.UserID)
+		conditions = append(conditions, fmt.Sprintf(
+			`(account_id IN (SELECT id FROM accounts WHERE user_id = %s)
+			OR (receiver_account_id IN (SELECT id FROM accounts WHERE user_id = %s) AND transaction_type = 'transfer'))`, p, p))
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "transaction_date >= "+arg(*filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "transaction_date < "+arg(*filter.To))
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "transaction_type = "+arg(filter.Type))
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "transaction_status = "+arg(filter.Status))
+	}
+	if filter.MinAmount != nil {
+		conditions = append(conditions, "amount >= "+arg(*filter.MinAmount))
+	}
+	if filter.MaxAmount != nil {
+		conditions = append(conditions, "amount <= "+arg(*filter.MaxAmount))
+	}
+	if filter.Cursor != nil {
+		conditions = append(conditions, fmt.Sprintf("(transaction_date, id) < (%s, %s)",
+			arg(filter.Cursor.Date), arg(filter.Cursor.ID)))
+	}
+
+	query := "SELECT * FROM transactions"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY transaction_date DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
 	transactions := []*models.Transaction{}
-	err := r.Db.Select(&transactions, "SELECT * FROM transactions WHERE account_id = $1", accountID)
+	err := r.Db.SelectContext(ctx, &transactions, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -69,12 +158,12 @@ func (r *TransactionRepository) GetTransactionsByAccountID(accountID int) ([]*mo
 }
 
 // GetTransactionByID gets a transaction by ID
-func (r *TransactionRepository) GetTransactionByID(id int) (*models.Transaction, error) {
+func (r *TransactionRepository) GetTransactionByID(ctx context.Context, id int) (*models.Transaction, error) {
 	transaction := &models.Transaction{}
-	err := r.Db.Get(transaction, "SELECT * FROM transactions WHERE id = $1", id)
+	err := r.Db.GetContext(ctx, transaction, "SELECT * FROM transactions WHERE id = $1", id)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			return nil, errors.New("transaction not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrTransactionNotFound
 		}
 		return nil, err
 	}
@@ -82,20 +171,81 @@ func (r *TransactionRepository) GetTransactionByID(id int) (*models.Transaction,
 	return transaction, nil
 }
 
-// Transfer transfers money from one account to another
-func (r *TransactionRepository) Transfer(fromAccountID int, toAccountID int, amount float64) error {
-	tx, err := r.Db.Beginx()
+// lockAccounts locks the accounts with SELECT ... FOR UPDATE in ascending ID
+// order, so concurrent transactions touching the same accounts cannot deadlock
+func (r *TransactionRepository) lockAccounts(ctx context.Context, tx *sqlx.Tx, accountIDs ...int) (map[int]*models.Account, error) {
+	ids := append([]int(nil), accountIDs...)
+	sort.Ints(ids)
+
+	accounts := make(map[int]*models.Account, len(ids))
+	for _, id := range ids {
+		if _, ok := accounts[id]; ok {
+			continue
+		}
+
+		account := &models.Account{}
+		err := tx.GetContext(ctx, account, "SELECT * FROM accounts WHERE id = $1 FOR UPDATE", id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, models.ErrAccountNotFound
+			}
+			return nil, err
+		}
+
+		accounts[id] = account
+	}
+
+	return accounts, nil
+}
+
+// debit checks ownership and funds of a locked account and debits it
+func (r *TransactionRepository) debit(ctx context.Context, tx *sqlx.Tx, account *models.Account, userID string, amount money.Money) error {
+	if account.UserID.String() != userID {
+		return models.ErrAccountNotOwned
+	}
+
+	if !account.Balance.SameCurrency(amount) {
+		return money.ErrCurrencyMismatch
+	}
+
+	if account.Balance.Cmp(amount) < 0 {
+		return models.ErrInsufficientFunds
+	}
+
+	_, err := tx.ExecContext(ctx, "UPDATE accounts SET balance = balance - $1 WHERE id = $2", amount, account.ID)
+	return err
+}
+
+// Transfer transfers money from one account owned by userID to another.
+// Both accounts are locked before the balance is checked, so the check and
+// the debit are atomic with respect to concurrent transfers and withdrawals.
+func (r *TransactionRepository) Transfer(ctx context.Context, userID string, fromAccountID int, toAccountID int, amount money.Money) (err error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepository.Transfer",
+		attribute.Int("account.from", fromAccountID), attribute.Int("account.to", toAccountID))
+	defer func() { tracing.End(span, err) }()
+
+	if fromAccountID == toAccountID {
+		return models.ErrSameAccount
+	}
+
+	tx, err := r.Db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec("UPDATE accounts SET balance = balance - $1 WHERE id = $2", amount, fromAccountID)
+	accounts, err := r.lockAccounts(ctx, tx, fromAccountID, toAccountID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	_, err = tx.Exec("UPDATE accounts SET balance = balance + $1 WHERE id = $2", amount, toAccountID)
+	err = r.debit(ctx, tx, accounts[fromAccountID], userID, amount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE accounts SET balance = balance + $1 WHERE id = $2", amount, toAccountID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -103,46 +253,67 @@ func (r *TransactionRepository) Transfer(fromAccountID int, toAccountID int, amo
 
 	transaction := &models.Transaction{
 		AccountID:            fromAccountID,
-		ReceiverAccountID:    toAccountID,
+		ReceiverAccountID:    &toAccountID,
 		Amount:               amount,
-		TransactionType:      "transfer",
+		TransactionType:      models.TransactionTypeTransfer,
 		TransactionStatus:    "success",
 		TransactionReference: utils.TransactionReference(),
 	}
 
-	err = r.CreateTransaction(tx, transaction)
+	err = r.CreateTransaction(ctx, tx, transaction)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	err = tx.Commit()
+	fromLedger, err := r.Ledger.GetCustomerLedgerAccountID(ctx, tx, fromAccountID)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	return nil
-}
+	toLedger, err := r.Ledger.GetCustomerLedgerAccountID(ctx, tx, toAccountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-// GetTransactionsByAccountID gets transactions by account ID
-func (r *TransactionRepository) GetTransactionsByUserID(userID string) ([]*models.Transaction, error) {
-	transactions := []*models.Transaction{}
-	err := r.Db.Select(&transactions, "SELECT * FROM transactions WHERE account_id = $1", userID)
+	err = r.postJournal(ctx, tx, transaction, models.Debit(fromLedger, amount), models.Credit(toLedger, amount))
 	if err != nil {
-		return nil, err
+		tx.Rollback()
+		return err
 	}
 
-	return transactions, nil
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetTransactionsByUserID gets transactions of all accounts owned by a user
+func (r *TransactionRepository) GetTransactionsByUserID(ctx context.Context, userID string) ([]*models.Transaction, error) {
+	return r.ListTransactions(ctx, &models.TransactionFilter{UserID: userID})
 }
 
 // Deposit deposits money into an account
-func (r *TransactionRepository) Deposit(accountID int, amount float64) error {
-	tx, err := r.Db.Beginx()
+func (r *TransactionRepository) Deposit(ctx context.Context, accountID int, amount money.Money) (err error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepository.Deposit", attribute.Int("account.id", accountID))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.Db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec("UPDATE accounts SET balance = balance + $1 WHERE id = $2", amount, accountID)
+	_, err = r.lockAccounts(ctx, tx, accountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE accounts SET balance = balance + $1 WHERE id = $2", amount, accountID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -150,14 +321,33 @@ func (r *TransactionRepository) Deposit(accountID int, amount float64) error {
 
 	transaction := &models.Transaction{
 		AccountID:            accountID,
-		ReceiverAccountID:    accountID,
+		ReceiverAccountID:    &accountID,
 		Amount:               amount,
-		TransactionType:      "deposit",
+		TransactionType:      models.TransactionTypeDeposit,
 		TransactionStatus:    "success",
 		TransactionReference: utils.TransactionReference(),
 	}
 
-	err = r.CreateTransaction(tx, transaction)
+	err = r.CreateTransaction(ctx, tx, transaction)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Cash deposited at an ATM is held in transit until it is collected
+	atmLedger, err := r.Ledger.GetSystemLedgerAccountID(ctx, tx, models.LedgerATMCashInTransit)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	customerLedger, err := r.Ledger.GetCustomerLedgerAccountID(ctx, tx, accountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = r.postJournal(ctx, tx, transaction, models.Debit(atmLedger, amount), models.Credit(customerLedger, amount))
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -171,29 +361,133 @@ func (r *TransactionRepository) Deposit(accountID int, amount float64) error {
 	return nil
 }
 
-// Withdrawal withdraws money from an account
-func (r *TransactionRepository) Withdraw(accountID int, atmId int, amount float64) error {
-	tx, err := r.Db.Beginx()
+// Withdraw withdraws money from an account owned by userID.
+// The account is locked before the balance is checked. It returns the pending
+// transaction, which ReverseWithdrawal undoes if the ATM does not dispense.
+func (r *TransactionRepository) Withdraw(ctx context.Context, userID string, accountID int, atmId int, amount money.Money) (transaction *models.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepository.Withdraw",
+		attribute.Int("account.id", accountID), attribute.Int("atm.id", atmId))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.Db.BeginTxx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = tx.Exec("UPDATE accounts SET balance = balance - $1 WHERE id = $2", amount, accountID)
+	accounts, err := r.lockAccounts(ctx, tx, accountID)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	transaction := &models.Transaction{
+	err = r.debit(ctx, tx, accounts[accountID], userID, amount)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	transaction = &models.Transaction{
 		AccountID:            accountID,
-		ReceiverAccountID:    atmId,
+		ReceiverAccountID:    &atmId,
 		Amount:               amount,
-		TransactionType:      "withdraw",
+		TransactionType:      models.TransactionTypeWithdraw,
 		TransactionStatus:    "pending",
 		TransactionReference: utils.TransactionReference(),
 	}
 
-	err = r.CreateTransaction(tx, transaction)
+	err = r.CreateTransaction(ctx, tx, transaction)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	customerLedger, err := r.Ledger.GetCustomerLedgerAccountID(ctx, tx, accountID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	atmLedger, err := r.Ledger.GetSystemLedgerAccountID(ctx, tx, models.LedgerATMCashInTransit)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = r.postJournal(ctx, tx, transaction, models.Debit(customerLedger, amount), models.Credit(atmLedger, amount))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+/*
+ReverseWithdrawal undoes a pending withdrawal the ATM did not dispense: the
+money is credited back, the transaction is marked failed and a reversing
+journal entry is posted. A withdrawal that is no longer pending is left alone.
+*/
+func (r *TransactionRepository) ReverseWithdrawal(ctx context.Context, transaction *models.Transaction) (err error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepository.ReverseWithdrawal", attribute.Int("transaction.id", transaction.ID))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.Db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.lockAccounts(ctx, tx, transaction.AccountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE transactions SET transaction_status = 'failed'
+	WHERE id = $1 AND transaction_type = $2 AND transaction_status = 'pending'`, transaction.ID, models.TransactionTypeWithdraw)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if rows == 0 {
+		tx.Rollback()
+		return models.ErrTransactionSettled
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE accounts SET balance = balance + $1 WHERE id = $2", transaction.Amount, transaction.AccountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	customerLedger, err := r.Ledger.GetCustomerLedgerAccountID(ctx, tx, transaction.AccountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	atmLedger, err := r.Ledger.GetSystemLedgerAccountID(ctx, tx, models.LedgerATMCashInTransit)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = r.Ledger.Post(ctx, tx, &models.JournalEntry{
+		TransactionID: &transaction.ID,
+		Description:   fmt.Sprintf("reversal of %s %s", transaction.TransactionType, transaction.TransactionReference),
+		Lines:         []models.JournalLine{models.Debit(atmLedger, transaction.Amount), models.Credit(customerLedger, transaction.Amount)},
+	})
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -204,5 +498,6 @@ func (r *TransactionRepository) Withdraw(accountID int, atmId int, amount float6
 		return err
 	}
 
+	transaction.TransactionStatus = "failed"
 	return nil
 }
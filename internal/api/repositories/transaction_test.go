@@ -0,0 +1,247 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/db"
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// testDBEnv names the Postgres database the repository tests run against.
+// The tests are skipped when it is not set, since they need a real database
+// to exercise row locks. The database is migrated to the latest version.
+const testDBEnv = "BANK_TEST_DB_URL"
+
+// testDB connects to the test database and migrates it
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	url := os.Getenv(testDBEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDBEnv)
+	}
+
+	pg, err := sqlx.Connect("pgx", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pg.Close() })
+
+	migrator, err := db.NewMigrator(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = migrator.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pg
+}
+
+// newTestTransactionRepository creates a TransactionRepository on pg
+func newTestTransactionRepository(pg *sqlx.DB) *TransactionRepository {
+	cfg := &config.Config{}
+	return NewTransactionRepository(pg, nil, cfg, NewLedgerRepository(pg, nil, cfg))
+}
+
+// createTestAccounts creates a user with one account per balance, funded
+// through deposits so the ledger matches the balances
+func createTestAccounts(t *testing.T, pg *sqlx.DB, repo *TransactionRepository, balances ...string) (string, []int) {
+	t.Helper()
+	ctx := context.Background()
+
+	userID := uuid.New()
+	name := "t" + strings.ReplaceAll(userID.String(), "-", "")[:20]
+	_, err := pg.ExecContext(ctx, `INSERT INTO users (id, username, email, password) VALUES ($1, $2, $3, 'x')`,
+		userID, name, name+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int{}
+	for _, balance := range balances {
+		var id int
+		err = pg.GetContext(ctx, &id, `INSERT INTO accounts (user_id, account_type, balance) VALUES ($1, 'savings', 0) RETURNING id`, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+
+		amount := money.MustParse(balance)
+		if amount.IsZero() {
+			continue
+		}
+
+		err = repo.Deposit(ctx, id, amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return userID.String(), ids
+}
+
+// assertBalance checks the balance of an account and that the balance
+// derived from its journal lines matches it
+func assertBalance(t *testing.T, pg *sqlx.DB, accountID int, want string) {
+	t.Helper()
+
+	row := struct {
+		Balance       money.Money `db:"balance"`
+		LedgerBalance money.Money `db:"ledger_balance"`
+	}{}
+	err := pg.GetContext(context.Background(), &row, `SELECT a.balance, COALESCE(-SUM(l.amount), 0) AS ledger_balance
+	FROM accounts a
+	JOIN ledger_accounts la ON la.account_id = a.id
+	LEFT JOIN journal_lines l ON l.ledger_account_id = la.id
+	WHERE a.id = $1
+	GROUP BY a.balance`, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if row.Balance.Cmp(money.MustParse(want)) != 0 {
+		t.Errorf("account %d: balance %s, want %s", accountID, row.Balance, want)
+	}
+	if row.LedgerBalance.Cmp(row.Balance) != 0 {
+		t.Errorf("account %d: ledger balance %s does not match balance %s", accountID, row.LedgerBalance, row.Balance)
+	}
+}
+
+func TestWithdrawConcurrentNoOverdraft(t *testing.T) {
+	pg := testDB(t)
+	repo := newTestTransactionRepository(pg)
+	userID, ids := createTestAccounts(t, pg, repo, "100.00")
+
+	const workers = 50
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		errs      []error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := repo.Withdraw(context.Background(), userID, ids[0], 1, money.MustParse("10.00"))
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, models.ErrInsufficientFunds):
+				errs = append(errs, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if succeeded != 10 {
+		t.Errorf("%d withdrawals succeeded, want 10", succeeded)
+	}
+	assertBalance(t, pg, ids[0], "0.00")
+}
+
+func TestTransferConcurrentNoLostUpdates(t *testing.T) {
+	pg := testDB(t)
+	repo := newTestTransactionRepository(pg)
+	userID, ids := createTestAccounts(t, pg, repo, "1000.00", "1000.00")
+
+	// Transfers run in both directions at once, which deadlocks unless the
+	// accounts are always locked in the same order
+	const workers = 40
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			errs <- repo.Transfer(context.Background(), userID, ids[0], ids[1], money.MustParse("3.00"))
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- repo.Transfer(context.Background(), userID, ids[1], ids[0], money.MustParse("1.00"))
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := repo.Withdraw(context.Background(), userID, ids[0], 1, money.MustParse("1.00"))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	// A: 1000 - 40*3 + 40*1 - 40*1, B: 1000 + 40*3 - 40*1
+	assertBalance(t, pg, ids[0], "880.00")
+	assertBalance(t, pg, ids[1], "1080.00")
+}
+
+func TestTransferRejectsOverdraft(t *testing.T) {
+	pg := testDB(t)
+	repo := newTestTransactionRepository(pg)
+	userID, ids := createTestAccounts(t, pg, repo, "5.00", "0.00")
+
+	err := repo.Transfer(context.Background(), userID, ids[0], ids[1], money.MustParse("5.01"))
+	if !errors.Is(err, models.ErrInsufficientFunds) {
+		t.Fatalf("got %v, want %v", err, models.ErrInsufficientFunds)
+	}
+
+	assertBalance(t, pg, ids[0], "5.00")
+}
+
+func TestReverseWithdrawal(t *testing.T) {
+	pg := testDB(t)
+	repo := newTestTransactionRepository(pg)
+	userID, ids := createTestAccounts(t, pg, repo, "50.00")
+	ctx := context.Background()
+
+	transaction, err := repo.Withdraw(ctx, userID, ids[0], 1, money.MustParse("20.00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, pg, ids[0], "30.00")
+
+	err = repo.ReverseWithdrawal(ctx, transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, pg, ids[0], "50.00")
+
+	got, err := repo.GetTransactionByID(ctx, transaction.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TransactionStatus != "failed" {
+		t.Errorf("status %q, want failed", got.TransactionStatus)
+	}
+
+	err = repo.ReverseWithdrawal(ctx, transaction)
+	if !errors.Is(err, models.ErrTransactionSettled) {
+		t.Fatalf("got %v, want %v", err, models.ErrTransactionSettled)
+	}
+	assertBalance(t, pg, ids[0], "50.00")
+}
@@ -1,12 +1,23 @@
 package repositories
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+
 	"github.com/bukharney/bank-core/internal/api/models"
 	"github.com/bukharney/bank-core/internal/config"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
 )
 
+// uniqueViolation is the postgres error code of a unique constraint violation
+const uniqueViolation = "23505"
+
+// usernameConstraint is the unique index on users.username
+const usernameConstraint = "users_username_key"
+
 // UserRepository is the repository for the user routes
 type UserRepository struct {
 	Cfg *config.Config
@@ -23,21 +34,27 @@ func NewUserRepository(db *sqlx.DB, rdb *redis.Client, cfg *config.Config) *User
 	}
 }
 
-// Register registers a new user
-func (r *UserRepository) Register(user *models.User, account *models.Account) error {
-	tx, err := r.Db.Beginx()
+// Register registers a new user, whose identity is not verified yet
+func (r *UserRepository) Register(ctx context.Context, user *models.User) error {
+	tx, err := r.Db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.NamedExec(`INSERT INTO users (id, email, password, first_name, last_name, username)
+	_, err = tx.NamedExecContext(ctx, `INSERT INTO users (id, email, password, first_name, last_name, username)
 	VALUES (:id, :email, :password, :first_name, :last_name, :username)`, user)
 	if err != nil {
 		tx.Rollback()
+		if isUniqueViolation(err) {
+			if uniqueConstraint(err) == usernameConstraint {
+				return models.ErrUsernameTaken
+			}
+			return models.ErrEmailTaken
+		}
 		return err
 	}
 
-	_, err = tx.NamedExec(`INSERT INTO accounts (user_id, balance, account_type) VALUES (:user_id, :balance, :account_type)`, account)
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_kyc (user_id, status) VALUES ($1, $2)", user.ID, models.KYCPending)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -52,22 +69,134 @@ func (r *UserRepository) Register(user *models.User, account *models.Account) er
 }
 
 // GetUserByEmail gets a user by email
-func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	user := &models.User{}
-	err := r.Db.Get(user, "SELECT * FROM users WHERE email = $1", email)
+	err := r.Db.GetContext(ctx, user, "SELECT * FROM users WHERE email = $1", email)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrUserNotFound
+		}
 		return nil, err
 	}
 
 	return user, nil
 }
 
-func (r *UserRepository) GetUserById(id string) (*models.User, error) {
+// GetUserById gets a user by ID
+func (r *UserRepository) GetUserById(ctx context.Context, id string) (*models.User, error) {
 	user := &models.User{}
-	err := r.Db.Get(user, "SELECT * FROM users WHERE id = $1", id)
+	err := r.Db.GetContext(ctx, user, "SELECT * FROM users WHERE id = $1", id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrUserNotFound
+		}
 		return nil, err
 	}
 
 	return user, nil
 }
+
+// UpdateRole updates the role of a user
+func (r *UserRepository) UpdateRole(ctx context.Context, id string, role string) error {
+	result, err := r.Db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdatePassword updates the password hash of a user
+func (r *UserRepository) UpdatePassword(ctx context.Context, id string, password string) error {
+	result, err := r.Db.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", password, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// MarkEmailVerified records that a user verified their email, keeping the
+// time of the first verification
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id string) error {
+	_, err := r.Db.ExecContext(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP) WHERE id = $1", id)
+	return err
+}
+
+// UpdateProfile updates the names, contact details and date of birth of a user
+func (r *UserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	result, err := r.Db.NamedExecContext(ctx, `UPDATE users SET first_name = :first_name, last_name = :last_name,
+	phone = :phone, address = :address, date_of_birth = :date_of_birth, updated_at = CURRENT_TIMESTAMP
+	WHERE id = :id`, user)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdateEmail changes the email of a user, who has to verify it again
+func (r *UserRepository) UpdateEmail(ctx context.Context, id string, email string) error {
+	result, err := r.Db.ExecContext(ctx, `UPDATE users SET email = $1, email_verified_at = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $2`, email, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return models.ErrEmailTaken
+		}
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// isUniqueViolation reports whether err is a postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// uniqueConstraint returns the name of the constraint a unique violation broke
+func uniqueConstraint(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.ConstraintName
+	}
+
+	return ""
+}
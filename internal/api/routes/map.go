@@ -1,62 +1,210 @@
 package routes
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/bukharney/bank-core/internal/api/controllers"
+	"github.com/bukharney/bank-core/internal/api/middleware"
 	"github.com/bukharney/bank-core/internal/api/repositories"
 	"github.com/bukharney/bank-core/internal/api/usecases"
+	"github.com/bukharney/bank-core/internal/blob"
 	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/db"
+	"github.com/bukharney/bank-core/internal/health"
+	"github.com/bukharney/bank-core/internal/keys"
+	"github.com/bukharney/bank-core/internal/mailer"
+	"github.com/bukharney/bank-core/internal/metrics"
+	"github.com/bukharney/bank-core/internal/password"
+	"github.com/bukharney/bank-core/internal/totp"
 	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
 )
 
 // MapHandler maps the routes to the handlers
-func MapHandler(config *config.Config, handler *http.ServeMux, pg *sqlx.DB, rdb *redis.Client) {
+func MapHandler(config *config.Config, handler *http.ServeMux, pg *sqlx.DB, rdb *redis.Client, keySet *keys.KeySet) error {
 	// Create the repositories
 	UserRepository := repositories.NewUserRepository(pg, rdb, config)
 	AuthRepository := repositories.NewAuthRepository(pg, rdb, config)
-	TransactionRepository := repositories.NewTransactionRepository(pg, rdb, config)
+	LedgerRepository := repositories.NewLedgerRepository(pg, rdb, config)
+	TransactionRepository := repositories.NewTransactionRepository(pg, rdb, config, LedgerRepository)
 	AccountRepository := repositories.NewAccountRepository(pg, rdb, config)
+	MFARepository := repositories.NewMFARepository(pg, rdb, config)
+	LockoutRepository := repositories.NewLockoutRepository(pg, rdb, config)
+	AuditRepository := repositories.NewAuditRepository(pg, rdb, config)
+	UserTokenRepository := repositories.NewUserTokenRepository(pg, rdb, config)
+	KYCRepository := repositories.NewKYCRepository(pg, rdb, config)
+
+	// TOTP secrets are encrypted at rest
+	sealer, err := totp.NewSealer(config.MFA.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	mail, err := mailer.New(config.Mail)
+	if err != nil {
+		return err
+	}
+
+	// Identity documents are kept outside the database
+	store, err := blob.New(config.Blob)
+	if err != nil {
+		return err
+	}
+
+	hasher := password.NewHasher(config.Password)
+	policy, err := password.NewPolicy(config.Password)
+	if err != nil {
+		return err
+	}
 
 	// Create the usecases
-	UserUseCase := usecases.NewUserUsecase(config, UserRepository, AccountRepository)
-	AuthUseCase := usecases.NewAuthUsecase(config, AuthRepository, UserRepository)
-	TransactionUseCase := usecases.NewTransactionUsecase(config, TransactionRepository, AccountRepository, UserRepository)
-	AccountUseCase := usecases.NewAccountUsecase(config, AccountRepository)
+	UserUseCase := usecases.NewUserUsecase(config, UserRepository, AccountRepository, UserTokenRepository, mail, hasher, policy, AuthRepository, AuditRepository)
+	MFAUseCase := usecases.NewMFAUsecase(config, MFARepository, UserRepository, LockoutRepository, sealer)
+	AuthUseCase := usecases.NewAuthUsecase(config, AuthRepository, UserRepository, MFAUseCase, LockoutRepository, AuditRepository, UserTokenRepository, mail, hasher, policy)
+	KYCUseCase := usecases.NewKYCUsecase(config, KYCRepository, UserRepository, AccountRepository, store, mail, AuditRepository)
+	TransactionUseCase := usecases.NewTransactionUsecase(config, TransactionRepository, AccountRepository, UserRepository, MFAUseCase, KYCUseCase)
+	AccountUseCase := usecases.NewAccountUsecase(config, AccountRepository, KYCUseCase)
+	LedgerUseCase := usecases.NewLedgerUsecase(config, LedgerRepository, UserRepository)
+
+	// Create the readiness checks
+	migrator, err := db.NewMigrator(pg)
+	if err != nil {
+		return err
+	}
+
+	checks := []health.Check{
+		health.Postgres(pg),
+		health.Redis(rdb),
+		health.Migrations(migrator),
+	}
+	// An unreachable ATM degrades withdrawals but should not take the API out of rotation
+	for id := 1; id <= config.ATM.Count; id++ {
+		checks = append(checks, health.HTTP(fmt.Sprintf("atm-%d", id), config.ATM.URL(id)+"/atm/health", false))
+	}
 
 	// Create the handlers
-	UserHandler := controllers.NewUserController(config, UserUseCase)
-	AuthHandler := controllers.NewAuthController(config, AuthUseCase)
-	TransactionHandler := controllers.NewTransactionController(config, TransactionUseCase)
-	AccountHandler := controllers.NewAccountController(config, AccountUseCase)
+	handlers := &handlers{
+		User:        controllers.NewUserController(config, UserUseCase),
+		Auth:        controllers.NewAuthController(config, AuthUseCase),
+		MFA:         controllers.NewMFAController(config, MFAUseCase),
+		Transaction: controllers.NewTransactionController(config, TransactionUseCase),
+		Account:     controllers.NewAccountController(config, AccountUseCase),
+		Ledger:      controllers.NewLedgerController(config, LedgerUseCase),
+		KYC:         controllers.NewKYCController(config, KYCUseCase),
+		Keys:        controllers.NewKeysController(config, keySet),
+		Health:      controllers.NewHealthController(config, health.NewChecker(config.Health.CheckTimeout, checks...)),
+	}
+
+	err = metrics.RegisterPools(pg, rdb)
+	if err != nil {
+		return err
+	}
+
+	// Every route is checked against the access matrix in Permissions, and
+	// money-moving routes can be retried safely with an Idempotency-Key header
+	registerRoutes(handler, handlers, middleware.Authorize(Permissions), middleware.Idempotency(config, rdb))
+
+	return nil
+}
+
+// handlers are the controllers the routes are mapped to
+type handlers struct {
+	User        *controllers.UserController
+	Auth        *controllers.AuthController
+	MFA         *controllers.MFAController
+	Transaction *controllers.TransactionController
+	Account     *controllers.AccountController
+	Ledger      *controllers.LedgerController
+	KYC         *controllers.KYCController
+	Keys        *controllers.KeysController
+	Health      *controllers.HealthController
+}
+
+/*
+registerRoutes maps the routes to h on handler and returns their full
+patterns, as labelled by middleware.Route and middleware.Routed, so they can
+be checked against Permissions.
+*/
+func registerRoutes(handler *http.ServeMux, h *handlers, authorize func(http.Handler) http.Handler, idempotent func(http.Handler) http.Handler) []string {
+	routes := &routeTable{}
+
+	// Health routes
+	routes.route(handler, "GET /healthz", http.HandlerFunc(h.Health.LivenessHandler), authorize)
+	routes.route(handler, "GET /readyz", http.HandlerFunc(h.Health.ReadinessHandler), authorize)
+
+	// Public keys for verifying tokens
+	routes.route(handler, "GET /.well-known/jwks.json", http.HandlerFunc(h.Keys.JWKSHandler), authorize)
+
+	// Metrics route
+	routes.route(handler, "GET /metrics", metrics.Handler(), authorize)
 
 	// Transaction routes
-	transactionRouter := http.NewServeMux()
-	transactionRouter.HandleFunc("POST /transfer", TransactionHandler.TransferHandler)
-	transactionRouter.HandleFunc("POST /deposit", TransactionHandler.DepositHandler)
-	transactionRouter.HandleFunc("POST /withdraw", TransactionHandler.WithdrawHandler)
-	transactionRouter.HandleFunc("PATCH /status", TransactionHandler.UpdateTransactionStatusHandler)
-	handler.Handle("/transaction/", http.StripPrefix("/transaction", transactionRouter))
+	transactionRouter := routes.mux("/transaction")
+	transactionRouter.Handle("POST /transfer", idempotent(http.HandlerFunc(h.Transaction.TransferHandler)))
+	transactionRouter.Handle("POST /deposit", idempotent(http.HandlerFunc(h.Transaction.DepositHandler)))
+	transactionRouter.Handle("POST /withdraw", idempotent(http.HandlerFunc(h.Transaction.WithdrawHandler)))
+	transactionRouter.HandleFunc("PATCH /status", h.Transaction.UpdateTransactionStatusHandler)
+	transactionRouter.HandleFunc("GET /{$}", h.Transaction.GetTransactionsHandler)
+	transactionRouter.HandleFunc("GET /{id}", h.Transaction.GetTransactionByIDHandler)
+	routes.mount(handler, transactionRouter, authorize)
 
 	// Account routes
-	accountRouter := http.NewServeMux()
-	accountRouter.HandleFunc("POST /create", AccountHandler.CreateAccountHandler)
-	accountRouter.HandleFunc("GET /{id}", AccountHandler.GetAccountByIDHandler)
-	accountRouter.HandleFunc("GET /", AccountHandler.GetAccountHandler)
-	handler.Handle("/account/", http.StripPrefix("/account", accountRouter))
+	accountRouter := routes.mux("/account")
+	accountRouter.HandleFunc("POST /create", h.Account.CreateAccountHandler)
+	accountRouter.HandleFunc("GET /{id}", h.Account.GetAccountByIDHandler)
+	accountRouter.HandleFunc("GET /{id}/transactions", h.Transaction.GetAccountTransactionsHandler)
+	accountRouter.HandleFunc("GET /", h.Account.GetAccountHandler)
+	routes.mount(handler, accountRouter, authorize)
+
+	// Ledger routes
+	ledgerRouter := routes.mux("/ledger")
+	ledgerRouter.HandleFunc("GET /trial-balance", h.Ledger.TrialBalanceHandler)
+	routes.mount(handler, ledgerRouter, authorize)
 
 	// User routes
-	userRouter := http.NewServeMux()
-	userRouter.HandleFunc("POST /register", UserHandler.RegisterHandler)
-	handler.Handle("/user/", http.StripPrefix("/user", userRouter))
+	userRouter := routes.mux("/user")
+	userRouter.HandleFunc("POST /register", h.User.RegisterHandler)
+	userRouter.HandleFunc("GET /verify", h.User.VerifyEmailHandler)
+	userRouter.HandleFunc("GET /me", h.User.GetMeHandler)
+	userRouter.HandleFunc("PATCH /me", h.User.UpdateMeHandler)
+	userRouter.HandleFunc("POST /me/email", h.User.ChangeEmailHandler)
+	userRouter.HandleFunc("POST /me/password", h.User.ChangePasswordHandler)
+	routes.mount(handler, userRouter, authorize)
+
+	// KYC routes
+	kycRouter := routes.mux("/kyc")
+	kycRouter.HandleFunc("GET /{$}", h.KYC.GetKYCHandler)
+	kycRouter.HandleFunc("POST /documents", h.KYC.UploadDocumentHandler)
+	kycRouter.HandleFunc("POST /submit", h.KYC.SubmitHandler)
+	kycRouter.HandleFunc("GET /reviews", h.KYC.ListReviewsHandler)
+	kycRouter.HandleFunc("GET /users/{id}", h.KYC.GetUserKYCHandler)
+	kycRouter.HandleFunc("POST /users/{id}/approve", h.KYC.ApproveHandler)
+	kycRouter.HandleFunc("POST /users/{id}/reject", h.KYC.RejectHandler)
+	kycRouter.HandleFunc("GET /documents/{id}", h.KYC.GetDocumentHandler)
+	routes.mount(handler, kycRouter, authorize)
 
 	// Auth routes
-	authRouter := http.NewServeMux()
-	authRouter.HandleFunc("POST /login", AuthHandler.LoginHandler)
-	authRouter.HandleFunc("GET /logout", AuthHandler.LogoutHandler)
-	authRouter.HandleFunc("GET /me", AuthHandler.MeHandler)
-	authRouter.HandleFunc("GET /refresh", AuthHandler.RefreshTokenHandler)
-	authRouter.HandleFunc("GET /test", AuthHandler.TestHandler)
-	handler.Handle("/auth/", http.StripPrefix("/auth", authRouter))
+	authRouter := routes.mux("/auth")
+	authRouter.HandleFunc("POST /login", h.Auth.LoginHandler)
+	authRouter.HandleFunc("POST /login/mfa", h.Auth.LoginMFAHandler)
+	authRouter.HandleFunc("POST /forgot-password", h.Auth.ForgotPasswordHandler)
+	authRouter.HandleFunc("POST /reset-password", h.Auth.ResetPasswordHandler)
+	authRouter.HandleFunc("POST /mfa/enroll", h.MFA.EnrollHandler)
+	authRouter.HandleFunc("POST /mfa/confirm", h.MFA.ConfirmHandler)
+	authRouter.HandleFunc("POST /mfa/disable", h.MFA.DisableHandler)
+	authRouter.HandleFunc("POST /logout", h.Auth.LogoutHandler)
+	authRouter.HandleFunc("GET /me", h.Auth.MeHandler)
+	authRouter.HandleFunc("POST /refresh", h.Auth.RefreshTokenHandler)
+	authRouter.HandleFunc("GET /sessions", h.Auth.ListSessionsHandler)
+	authRouter.HandleFunc("DELETE /sessions", h.Auth.LogoutAllHandler)
+	authRouter.HandleFunc("DELETE /sessions/{id}", h.Auth.RevokeSessionHandler)
+	authRouter.HandleFunc("GET /users/{id}/sessions", h.Auth.ListUserSessionsHandler)
+	authRouter.HandleFunc("DELETE /users/{id}/sessions", h.Auth.RevokeUserSessionsHandler)
+	authRouter.HandleFunc("PUT /users/{id}/role", h.Auth.AssignRoleHandler)
+	authRouter.HandleFunc("DELETE /users/{id}/lockout", h.Auth.UnlockUserHandler)
+	authRouter.HandleFunc("GET /test", h.Auth.TestHandler)
+	routes.mount(handler, authRouter, authorize)
+
+	return routes.patterns
 }
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"github.com/bukharney/bank-core/internal/api/middleware"
+	"github.com/bukharney/bank-core/internal/api/models"
+)
+
+/*
+Permissions is the access matrix of the API: who may call every route
+registered in MapHandler, keyed by method and full path.
+
+A route missing from this map is denied to everyone, so every new route
+needs an entry here. Which roles hold each permission is defined in
+models.RolePermissions.
+*/
+var Permissions = middleware.Policy{
+	// Infrastructure, the metrics expose internals so scrapers authenticate
+	"GET /healthz":               middleware.Public,
+	"GET /readyz":                middleware.Public,
+	"GET /metrics":               middleware.Require(models.PermMetricsRead),
+	"GET /.well-known/jwks.json": middleware.Public,
+
+	// Transactions
+	"POST /transaction/transfer": middleware.Require(models.PermTransactionsTransfer),
+	"POST /transaction/deposit":  middleware.Require(models.PermTransactionsDeposit),
+	"POST /transaction/withdraw": middleware.Require(models.PermTransactionsWithdraw),
+	"PATCH /transaction/status":  middleware.Require(models.PermTransactionsStatus),
+	"GET /transaction/{$}":       middleware.Require(models.PermTransactionsRead),
+	"GET /transaction/{id}":      middleware.Require(models.PermTransactionsRead),
+
+	// Accounts
+	"POST /account/create":           middleware.Require(models.PermAccountsCreate),
+	"GET /account/{id}":              middleware.Require(models.PermAccountsRead),
+	"GET /account/{id}/transactions": middleware.Require(models.PermTransactionsRead),
+	"GET /account/":                  middleware.Require(models.PermAccountsRead),
+
+	// Ledger
+	"GET /ledger/trial-balance": middleware.Require(models.PermLedgerRead),
+
+	// Users
+	"POST /user/register":    middleware.Public,
+	"GET /user/verify":       middleware.Public,
+	"GET /user/me":           middleware.Authenticated,
+	"PATCH /user/me":         middleware.Authenticated,
+	"POST /user/me/email":    middleware.Authenticated,
+	"POST /user/me/password": middleware.Authenticated,
+
+	// KYC, users verify their own identity and compliance officers review it
+	"GET /kyc/{$}":                 middleware.Authenticated,
+	"POST /kyc/documents":          middleware.Authenticated,
+	"POST /kyc/submit":             middleware.Authenticated,
+	"GET /kyc/reviews":             middleware.Require(models.PermKYCReview),
+	"GET /kyc/users/{id}":          middleware.Require(models.PermKYCReview),
+	"POST /kyc/users/{id}/approve": middleware.Require(models.PermKYCReview),
+	"POST /kyc/users/{id}/reject":  middleware.Require(models.PermKYCReview),
+	"GET /kyc/documents/{id}":      middleware.Require(models.PermKYCReview),
+
+	// Auth, the refresh and logout routes authenticate with the refresh token.
+	// They change state, so they are POST and checked for CSRF.
+	"POST /auth/login":                 middleware.Public,
+	"POST /auth/login/mfa":             middleware.Public,
+	"POST /auth/forgot-password":       middleware.Public,
+	"POST /auth/reset-password":        middleware.Public,
+	"POST /auth/logout":                middleware.Public,
+	"POST /auth/refresh":               middleware.Public,
+	"GET /auth/test":                   middleware.Public,
+	"GET /auth/me":                     middleware.Authenticated,
+	"POST /auth/mfa/enroll":            middleware.Authenticated,
+	"POST /auth/mfa/confirm":           middleware.Authenticated,
+	"POST /auth/mfa/disable":           middleware.Authenticated,
+	"GET /auth/sessions":               middleware.Authenticated,
+	"DELETE /auth/sessions":            middleware.Authenticated,
+	"DELETE /auth/sessions/{id}":       middleware.Authenticated,
+	"GET /auth/users/{id}/sessions":    middleware.Require(models.PermUsersSessions),
+	"DELETE /auth/users/{id}/sessions": middleware.Require(models.PermUsersSessions),
+	"PUT /auth/users/{id}/role":        middleware.Require(models.PermUsersRoles),
+	"DELETE /auth/users/{id}/lockout":  middleware.Require(models.PermUsersUnlock),
+}
@@ -0,0 +1,230 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bukharney/bank-core/internal/api/middleware"
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/keys"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// anonymous is the caller without an access token
+const anonymous = "anonymous"
+
+var (
+	everyone      = []string{anonymous, models.RoleUser, models.RoleAdmin, models.RoleATM, models.RoleCompliance}
+	authenticated = []string{models.RoleUser, models.RoleAdmin, models.RoleATM, models.RoleCompliance}
+	customers     = []string{models.RoleUser, models.RoleAdmin}
+	atms          = []string{models.RoleATM}
+	admins        = []string{models.RoleAdmin}
+	reviewers     = []string{models.RoleAdmin, models.RoleCompliance}
+)
+
+// expectedAccess lists the callers allowed on every route, every other
+// caller must be denied
+var expectedAccess = map[string][]string{
+	"GET /healthz":               everyone,
+	"GET /readyz":                everyone,
+	"GET /metrics":               admins,
+	"GET /.well-known/jwks.json": everyone,
+
+	"POST /transaction/transfer": customers,
+	"POST /transaction/deposit":  atms,
+	"POST /transaction/withdraw": customers,
+	"PATCH /transaction/status":  admins,
+	"GET /transaction/{$}":       customers,
+	"GET /transaction/{id}":      customers,
+
+	"POST /account/create":           customers,
+	"GET /account/{id}":              customers,
+	"GET /account/{id}/transactions": customers,
+	"GET /account/":                  customers,
+
+	"GET /ledger/trial-balance": admins,
+
+	"POST /user/register":    everyone,
+	"GET /user/verify":       everyone,
+	"GET /user/me":           authenticated,
+	"PATCH /user/me":         authenticated,
+	"POST /user/me/email":    authenticated,
+	"POST /user/me/password": authenticated,
+
+	"GET /kyc/{$}":                 authenticated,
+	"POST /kyc/documents":          authenticated,
+	"POST /kyc/submit":             authenticated,
+	"GET /kyc/reviews":             reviewers,
+	"GET /kyc/users/{id}":          reviewers,
+	"POST /kyc/users/{id}/approve": reviewers,
+	"POST /kyc/users/{id}/reject":  reviewers,
+	"GET /kyc/documents/{id}":      reviewers,
+
+	"POST /auth/login":                 everyone,
+	"POST /auth/login/mfa":             everyone,
+	"POST /auth/forgot-password":       everyone,
+	"POST /auth/reset-password":        everyone,
+	"POST /auth/logout":                everyone,
+	"POST /auth/refresh":               everyone,
+	"GET /auth/test":                   everyone,
+	"GET /auth/me":                     authenticated,
+	"POST /auth/mfa/enroll":            authenticated,
+	"POST /auth/mfa/confirm":           authenticated,
+	"POST /auth/mfa/disable":           authenticated,
+	"GET /auth/sessions":               authenticated,
+	"DELETE /auth/sessions":            authenticated,
+	"DELETE /auth/sessions/{id}":       authenticated,
+	"GET /auth/users/{id}/sessions":    admins,
+	"DELETE /auth/users/{id}/sessions": admins,
+	"PUT /auth/users/{id}/role":        admins,
+	"DELETE /auth/users/{id}/lockout":  admins,
+}
+
+// sessionStore is an AuthRepository that never revokes a session
+type sessionStore struct {
+	models.AuthRepository
+}
+
+// IsSessionRevoked implements models.AuthRepository
+func (sessionStore) IsSessionRevoked(ctx context.Context, sessionId string) (bool, error) {
+	return false, nil
+}
+
+// registeredRoutes returns the full patterns of every route in MapHandler
+func registeredRoutes() []string {
+	passthrough := func(next http.Handler) http.Handler { return next }
+	return registerRoutes(http.NewServeMux(), &handlers{}, passthrough, passthrough)
+}
+
+// newTestConfig returns a config with freshly generated signing keys
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	keySet, err := keys.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.SetKeySet(keySet)
+
+	return config.NewConfig()
+}
+
+// accessToken returns an access token of a new session of role
+func accessToken(t *testing.T, cfg *config.Config, role string) string {
+	t.Helper()
+
+	claims := utils.NewTokenClaims(cfg, uuid.NewString(), uuid.NewString(), role, false)
+	token, err := utils.GenerateToken(cfg, claims, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// authorizedRoute serves pattern behind Authorize with policy, answering 200
+// to the requests it lets through
+func authorizedRoute(cfg *config.Config, pattern string, policy middleware.Policy) http.Handler {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return middleware.MetricsMiddleware(
+		middleware.AuthMiddleware(cfg, sessionStore{})(
+			middleware.Route(pattern, ok, middleware.Authorize(policy)),
+		),
+	)
+}
+
+// call sends a request to pattern as caller and returns the response status
+func call(t *testing.T, cfg *config.Config, h http.Handler, pattern string, caller string) int {
+	t.Helper()
+
+	method, _, _ := strings.Cut(pattern, " ")
+	r := httptest.NewRequest(method, "/", nil)
+	if caller != anonymous {
+		r.Header.Set("Authorization", "Bearer "+accessToken(t, cfg, caller))
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
+
+func TestEveryRouteHasPolicy(t *testing.T) {
+	routes := registeredRoutes()
+
+	for _, route := range routes {
+		if _, ok := Permissions[route]; !ok {
+			t.Errorf("route %q has no entry in Permissions", route)
+		}
+		if _, ok := expectedAccess[route]; !ok {
+			t.Errorf("route %q has no entry in expectedAccess", route)
+		}
+	}
+
+	for route := range Permissions {
+		if !slices.Contains(routes, route) {
+			t.Errorf("Permissions has an entry for unregistered route %q", route)
+		}
+	}
+	for route := range Timeouts(config.NewConfig()) {
+		if !slices.Contains(routes, route) {
+			t.Errorf("Timeouts has an entry for unregistered route %q", route)
+		}
+	}
+}
+
+func TestAuthorizeFailsClosed(t *testing.T) {
+	cfg := newTestConfig(t)
+	h := authorizedRoute(cfg, "GET /unlisted", Permissions)
+
+	for _, caller := range everyone {
+		if status := call(t, cfg, h, "GET /unlisted", caller); status != http.StatusForbidden {
+			t.Errorf("%s: route without a policy answered %d, want %d", caller, status, http.StatusForbidden)
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	for _, route := range registeredRoutes() {
+		t.Run(route, func(t *testing.T) {
+			h := authorizedRoute(cfg, route, Permissions)
+			allowed := expectedAccess[route]
+
+			for _, caller := range everyone {
+				status := call(t, cfg, h, route, caller)
+
+				want := http.StatusOK
+				switch {
+				case slices.Contains(allowed, caller):
+				case caller == anonymous:
+					want = http.StatusUnauthorized
+				default:
+					want = http.StatusForbidden
+				}
+
+				if status != want {
+					t.Errorf("%s: got %d, want %d", caller, status, want)
+				}
+			}
+		})
+	}
+}
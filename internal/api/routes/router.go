@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/bukharney/bank-core/internal/api/middleware"
+)
+
+// routeTable records the full pattern of every route registered through it
+type routeTable struct {
+	patterns []string
+}
+
+// route registers h as pattern on handler, labelled with pattern and passed
+// through middlewares such as Authorize
+func (t *routeTable) route(handler *http.ServeMux, pattern string, h http.Handler, middlewares ...func(http.Handler) http.Handler) {
+	handler.Handle(pattern, middleware.Route(pattern, h, middlewares...))
+	t.patterns = append(t.patterns, pattern)
+}
+
+// mux creates a sub-router to be mounted under prefix
+func (t *routeTable) mux(prefix string) *subRouter {
+	return &subRouter{ServeMux: http.NewServeMux(), prefix: prefix, table: t}
+}
+
+// mount mounts sub on handler under its prefix, labelled by
+// middleware.Routed and passed through middlewares such as Authorize
+func (t *routeTable) mount(handler *http.ServeMux, sub *subRouter, middlewares ...func(http.Handler) http.Handler) {
+	handler.Handle(sub.prefix+"/", http.StripPrefix(sub.prefix, middleware.Routed(sub.prefix, sub.ServeMux, middlewares...)))
+}
+
+// subRouter is a ServeMux mounted under a prefix that records its routes
+type subRouter struct {
+	*http.ServeMux
+	prefix string
+	table  *routeTable
+}
+
+// Handle registers h as pattern
+func (r *subRouter) Handle(pattern string, h http.Handler) {
+	r.ServeMux.Handle(pattern, h)
+	r.table.patterns = append(r.table.patterns, middleware.RoutePattern(r.prefix, pattern))
+}
+
+// HandleFunc registers h as pattern
+func (r *subRouter) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	r.Handle(pattern, http.HandlerFunc(h))
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/bukharney/bank-core/internal/api/middleware"
+	"github.com/bukharney/bank-core/internal/config"
+)
+
+// Timeouts lists the routes that may outlast server.request_timeout, keyed
+// like Permissions. They are served unbuffered so their bodies stream.
+func Timeouts(cfg *config.Config) middleware.Timeouts {
+	return middleware.Timeouts{
+		"POST /kyc/documents":     cfg.KYC.TransferTimeout,
+		"GET /kyc/documents/{id}": cfg.KYC.TransferTimeout,
+	}
+}
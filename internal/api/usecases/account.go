@@ -1,8 +1,12 @@
 package usecases
 
 import (
+	"context"
+
 	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/apperr"
 	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/money"
 	"github.com/bukharney/bank-core/internal/utils"
 )
 
@@ -10,38 +14,45 @@ import (
 type AccountUsecase struct {
 	Cfg  *config.Config
 	Repo models.AccountRepository
+	KYC  models.KYCUsecase
 }
 
 // NewAccountUsecase creates a new AccountUsecase
-func NewAccountUsecase(cfg *config.Config, repo models.AccountRepository) models.AccountUsecase {
+func NewAccountUsecase(cfg *config.Config, repo models.AccountRepository, kyc models.KYCUsecase) models.AccountUsecase {
 	return &AccountUsecase{
 		Cfg:  cfg,
 		Repo: repo,
+		KYC:  kyc,
 	}
 }
 
 // GetAccountByID gets an account by its ID
-func (u *AccountUsecase) GetAccountByID(accountID string) (*models.Account, error) {
+func (u *AccountUsecase) GetAccountByID(ctx context.Context, accountID string) (*models.Account, error) {
 	id, err := utils.StringToInt(accountID)
 	if err != nil {
-		return nil, err
+		return nil, apperr.BadRequest("invalid_id", "account id must be a number")
 	}
 
-	return u.Repo.GetAccountByID(id)
+	return u.Repo.GetAccountByID(ctx, id)
 }
 
 // GetAccountByUserID gets an account by its user ID
-func (u *AccountUsecase) GetAccountsByUserID(userID string) (*[]models.Account, error) {
-	return u.Repo.GetAccountsByUserID(userID)
+func (u *AccountUsecase) GetAccountsByUserID(ctx context.Context, userID string) (*[]models.Account, error) {
+	return u.Repo.GetAccountsByUserID(ctx, userID)
 }
 
-// CreateAccount creates an account for a user
-func (u *AccountUsecase) CreateAccount(userID string) error {
+// CreateAccount creates an account for a user whose identity is verified
+func (u *AccountUsecase) CreateAccount(ctx context.Context, userID string) error {
+	err := u.KYC.RequireApproved(ctx, userID)
+	if err != nil {
+		return err
+	}
+
 	account := &models.CreateAccountRequest{
 		UserID:      userID,
-		Balance:     0,
+		Balance:     money.FromMinor(0),
 		AccountType: "savings",
 	}
 
-	return u.Repo.CreateAccount(account)
+	return u.Repo.CreateAccount(ctx, account)
 }
@@ -1,111 +1,519 @@
 package usecases
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"time"
 
 	"github.com/bukharney/bank-core/internal/api/models"
 	"github.com/bukharney/bank-core/internal/config"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/mailer"
+	"github.com/bukharney/bank-core/internal/password"
 	"github.com/bukharney/bank-core/internal/utils"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthUsecase is the usecase for the auth routes
 type AuthUsecase struct {
-	Cfg      *config.Config
-	Repo     models.AuthRepository
-	UserRepo models.UserRepository
+	Cfg       *config.Config
+	Repo      models.AuthRepository
+	UserRepo  models.UserRepository
+	MFA       models.MFAUsecase
+	Lockout   models.LockoutRepository
+	Audit     models.AuditRepository
+	TokenRepo models.UserTokenRepository
+	Mailer    mailer.Mailer
+	Hasher    *password.Hasher
+	Policy    *password.Policy
 }
 
 // NewAuthUsecase creates a new AuthUsecase
-func NewAuthUsecase(cfg *config.Config, repo models.AuthRepository, userRepo models.UserRepository) models.AuthUsecase {
+func NewAuthUsecase(cfg *config.Config, repo models.AuthRepository, userRepo models.UserRepository, mfa models.MFAUsecase, lockout models.LockoutRepository, audit models.AuditRepository, tokenRepo models.UserTokenRepository, mail mailer.Mailer, hasher *password.Hasher, policy *password.Policy) models.AuthUsecase {
 	return &AuthUsecase{
-		UserRepo: userRepo,
-		Repo:     repo,
-		Cfg:      cfg,
+		Hasher:    hasher,
+		Policy:    policy,
+		TokenRepo: tokenRepo,
+		Mailer:    mail,
+		Audit:     audit,
+		Lockout:   lockout,
+		MFA:       mfa,
+		UserRepo:  userRepo,
+		Repo:      repo,
+		Cfg:       cfg,
 	}
 }
 
-// Login logs in a user
-func (u *AuthUsecase) Login(user *models.UserCredentials) (*models.LoginResponse, error) {
-	dbUser, err := u.UserRepo.GetUserByEmail(user.Email)
+/*
+Login logs in a user and starts a new session for the client's device.
+
+Failed logins are counted per email and per IP: each failure delays the next
+attempt for the email a little longer, and too many failures lock the email
+or IP out for a while. Unknown emails and wrong passwords get the same error
+after the same amount of work, so logins do not reveal who has an account.
+
+Users who have enabled MFA get an MFA challenge token instead of a session,
+which they complete with a code through LoginMFA.
+*/
+func (u *AuthUsecase) Login(ctx context.Context, user *models.UserCredentials, client *models.ClientInfo) (*models.LoginResponse, error) {
+	email := models.NormalizeEmail(user.Email)
+
+	err := u.checkLoginBlocked(ctx, email, client.IP)
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			return nil, fmt.Errorf("user not found")
+		return nil, err
+	}
+
+	dbUser, err := u.UserRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			// Take as long as checking a real password
+			u.Hasher.VerifyDummy(user.Password)
+			return nil, u.loginFailed(ctx, email, client.IP, nil)
 		}
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(user.Password))
+	ok, rehash, err := u.Hasher.Verify(dbUser.Password, user.Password)
 	if err != nil {
-		return nil, fmt.Errorf("invalid password")
+		return nil, err
+	}
+
+	if !ok {
+		return nil, u.loginFailed(ctx, email, client.IP, dbUser)
+	}
+
+	if rehash {
+		u.rehashPassword(ctx, dbUser.ID.String(), user.Password)
 	}
 
-	refreshToken, err := utils.GenerateToken(u.Cfg, dbUser.ID, true)
+	err = u.Lockout.ClearLoginFailures(ctx, models.LoginScopeEmail, email)
 	if err != nil {
 		return nil, err
 	}
 
-	strId := dbUser.ID.String()
-	err = u.Repo.UpdateRefreshToken(strId, refreshToken)
+	enabled, err := u.MFA.IsEnabled(ctx, dbUser.ID.String())
 	if err != nil {
 		return nil, err
 	}
 
-	accessToken, err := utils.GenerateToken(u.Cfg, dbUser.ID, false)
+	if enabled {
+		mfaToken, err := u.MFA.StartChallenge(ctx, dbUser.ID.String())
+		if err != nil {
+			return nil, err
+		}
+
+		return &models.LoginResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		}, nil
+	}
+
+	return u.startSession(ctx, dbUser, client)
+}
+
+// LoginMFA completes a login with MFA and starts a new session for the
+// client's device
+func (u *AuthUsecase) LoginMFA(ctx context.Context, req *models.MFALoginRequest, client *models.ClientInfo) (*models.LoginResponse, error) {
+	userId, err := u.MFA.CompleteChallenge(ctx, req.MFAToken, req.Code)
 	if err != nil {
 		return nil, err
 	}
 
-	return &models.LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-	}, nil
+	user, err := u.UserRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.startSession(ctx, user, client)
+}
+
+// rehashPassword replaces the password hash of a user with one of the
+// configured algorithm and cost. The login goes on if it fails.
+func (u *AuthUsecase) rehashPassword(ctx context.Context, userId string, pw string) {
+	hash, err := u.Hasher.Hash(pw)
+	if err == nil {
+		err = u.UserRepo.UpdatePassword(ctx, userId, hash)
+	}
+	if err != nil {
+		logger.WithContext(ctx).Errorw("failed to rehash password",
+			"user_id", userId,
+			"error", err,
+		)
+	}
+}
+
+// startSession starts a new session of user on the client's device
+func (u *AuthUsecase) startSession(ctx context.Context, user *models.User, client *models.ClientInfo) (*models.LoginResponse, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:         uuid.NewString(),
+		UserID:     user.ID.String(),
+		Role:       user.Role,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+
+	token, err := u.issueTokens(session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.Repo.CreateSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
 }
 
-// Logout logs out a user
-func (u *AuthUsecase) Logout(refreshToken string) error {
-	userId, err := utils.ParseToken(u.Cfg, refreshToken, true)
+// Logout logs out a user by revoking the session of the refresh token
+func (u *AuthUsecase) Logout(ctx context.Context, refreshToken string) error {
+	claims, err := utils.ParseTokenClaims(u.Cfg, refreshToken, true)
+	if err != nil || claims.SessionID == "" {
+		return models.ErrInvalidRefreshToken
+	}
+
+	session, err := u.Repo.GetSession(ctx, claims.SessionID)
 	if err != nil {
-		return fmt.Errorf("invalid refresh token")
+		// The session has already expired or been revoked
+		if errors.Is(err, models.ErrSessionNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if session.UserID != claims.UserID {
+		return models.ErrInvalidRefreshToken
 	}
 
-	return u.Repo.UpdateRefreshToken(userId, "")
+	return u.Repo.DeleteSession(ctx, session)
 }
 
-// RefreshToken refreshes the access token
-func (u *AuthUsecase) RefreshToken(refreshToken string) (*models.LoginResponse, error) {
-	userId, err := utils.ParseToken(u.Cfg, refreshToken, true)
+/*
+RefreshToken rotates the refresh token of a session and issues a new token pair.
+
+Each refresh token can be used once. If a token that has already been rotated
+is presented again, either the legitimate client or an attacker holds a
+stolen copy, so the whole session is revoked and both have to log in again.
+*/
+func (u *AuthUsecase) RefreshToken(ctx context.Context, refreshToken string, client *models.ClientInfo) (*models.LoginResponse, error) {
+	claims, err := utils.ParseTokenClaims(u.Cfg, refreshToken, true)
+	if err != nil || claims.SessionID == "" {
+		return nil, models.ErrInvalidRefreshToken
+	}
+
+	session, err := u.Repo.GetSession(ctx, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.UserID != claims.UserID {
+		return nil, models.ErrInvalidRefreshToken
+	}
+
+	if session.TokenID != claims.ID {
+		return nil, u.revokeReusedSession(ctx, session)
+	}
+
+	usedTokenId := session.TokenID
+	session.UserAgent = client.UserAgent
+	session.IP = client.IP
+	session.LastUsedAt = time.Now()
+
+	token, err := u.issueTokens(session)
 	if err != nil {
-		return nil, fmt.Errorf("invalid refresh token")
+		return nil, err
+	}
+
+	err = u.Repo.RotateSession(ctx, session, usedTokenId)
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			return nil, u.revokeReusedSession(ctx, session)
+		}
+		return nil, err
 	}
 
-	accessToken, err := utils.GenerateToken(u.Cfg, uuid.MustParse(userId), false)
+	return token, nil
+}
+
+// issueTokens issues a new token pair for session and makes the new refresh
+// token the current token of the session
+func (u *AuthUsecase) issueTokens(session *models.Session) (*models.LoginResponse, error) {
+	refreshClaims := utils.NewTokenClaims(u.Cfg, session.UserID, session.ID, session.Role, true)
+	refreshToken, err := utils.GenerateToken(u.Cfg, refreshClaims, true)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err = utils.GenerateToken(u.Cfg, uuid.MustParse(userId), true)
+	accessClaims := utils.NewTokenClaims(u.Cfg, session.UserID, session.ID, session.Role, false)
+	accessToken, err := utils.GenerateToken(u.Cfg, accessClaims, false)
 	if err != nil {
 		return nil, err
 	}
 
+	session.TokenID = refreshClaims.ID
+	session.ExpiresAt = refreshClaims.ExpiresAt
+
 	return &models.LoginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 	}, nil
 }
 
-func (u *AuthUsecase) Me(token string) (*models.User, error) {
+// revokeReusedSession revokes a session whose refresh token was reused
+func (u *AuthUsecase) revokeReusedSession(ctx context.Context, session *models.Session) error {
+	logger.WithContext(ctx).Warnw("refresh token reused, revoking session",
+		"session_id", session.ID,
+		"user_id", session.UserID,
+	)
+
+	err := u.Repo.DeleteSession(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	return models.ErrRefreshTokenReused
+}
+
+// Me gets the profile of the user the access token belongs to
+func (u *AuthUsecase) Me(ctx context.Context, token string) (*models.Profile, error) {
 	userId, err := utils.GetUserIdFromToken(u.Cfg, token, false)
+	if err != nil {
+		return nil, models.ErrInvalidAccessToken
+	}
+
+	user, err := u.UserRepo.GetUserById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := u.UserRepo.GetUserById(userId)
+	return models.NewProfile(user), nil
+}
+
+// ListSessions lists the active sessions of a user, marking the current one
+func (u *AuthUsecase) ListSessions(ctx context.Context, userId string, currentSessionId string) ([]*models.Session, error) {
+	sessions, err := u.Repo.ListSessions(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	for _, session := range sessions {
+		session.Current = session.ID == currentSessionId
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of the sessions of a user
+func (u *AuthUsecase) RevokeSession(ctx context.Context, userId string, sessionId string) error {
+	session, err := u.Repo.GetSession(ctx, sessionId)
+	if err != nil {
+		if errors.Is(err, models.ErrSessionNotFound) {
+			return models.ErrUnknownSession
+		}
+		return err
+	}
+
+	// Do not reveal that sessions of other users exist
+	if session.UserID != userId {
+		return models.ErrUnknownSession
+	}
+
+	return u.Repo.DeleteSession(ctx, session)
+}
+
+// LogoutAll revokes every session of a user, logging them out on all devices
+func (u *AuthUsecase) LogoutAll(ctx context.Context, userId string) error {
+	sessions, err := u.Repo.ListSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		err = u.Repo.DeleteSession(ctx, session)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListUserSessions lists the active sessions of any user, for admins
+func (u *AuthUsecase) ListUserSessions(ctx context.Context, userId string) ([]*models.Session, error) {
+	return u.Repo.ListSessions(ctx, userId)
+}
+
+// RevokeUserSessions revokes every session of any user, for admins
+func (u *AuthUsecase) RevokeUserSessions(ctx context.Context, adminId string, userId string) error {
+	logger.WithContext(ctx).Infow("revoking all sessions of user",
+		"admin_id", adminId,
+		"user_id", userId,
+	)
+
+	return u.LogoutAll(ctx, userId)
+}
+
+/*
+AssignRole changes the role of a user, for admins.
+
+Tokens carry the role they were issued with, so the user's sessions are
+revoked and the new role takes effect when they log in again.
+*/
+func (u *AuthUsecase) AssignRole(ctx context.Context, adminId string, userId string, role string) error {
+	if !models.IsRole(role) {
+		return models.ErrUnknownRole
+	}
+
+	if adminId == userId {
+		return models.ErrOwnRole
+	}
+
+	err := u.UserRepo.UpdateRole(ctx, userId, role)
+	if err != nil {
+		return err
+	}
+
+	logger.WithContext(ctx).Infow("assigned role to user",
+		"admin_id", adminId,
+		"user_id", userId,
+		"role", role,
+	)
+
+	return u.LogoutAll(ctx, userId)
+}
+
+// UnlockUser lifts the login lockout of a user and resets their failed
+// login counter, for admins
+func (u *AuthUsecase) UnlockUser(ctx context.Context, adminId string, userId string, client *models.ClientInfo) error {
+	user, err := u.UserRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	err = u.Lockout.UnblockLogin(ctx, models.LoginScopeEmail, models.NormalizeEmail(user.Email))
+	if err != nil {
+		return err
+	}
+
+	err = u.Lockout.UnblockLogin(ctx, models.LoginScopeMFA, userId)
+	if err != nil {
+		return err
+	}
+
+	audit(ctx, u.Audit, &models.AuditEvent{
+		Type:    models.AuditLoginUnlocked,
+		UserID:  &userId,
+		ActorID: &adminId,
+		IP:      client.IP,
+	})
+
+	return nil
+}
+
+/*
+ForgotPassword emails a password reset link to the user with the email.
+
+It succeeds whether or not anyone has the email, so it cannot be used to
+find out who has an account. Only the latest link sent works.
+*/
+func (u *AuthUsecase) ForgotPassword(ctx context.Context, email string) error {
+	user, err := u.UserRepo.GetUserByEmail(ctx, models.NormalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	userId := user.ID.String()
+	err = u.TokenRepo.DeleteUserTokens(ctx, userId, models.TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	token, err := issueUserToken(ctx, u.TokenRepo, userId, models.TokenPurposePasswordReset, u.Cfg.Mail.ResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link, err := tokenLink(u.Cfg.Mail.ResetURL, token)
+	if err != nil {
+		return err
+	}
+
+	sendInBackground(ctx, u.Mailer, mailer.PasswordResetEmail(user.Email, link, u.Cfg.Mail.ResetTTL))
+	return nil
+}
+
+/*
+ResetPassword sets a new password with a password reset token.
+
+The new password is checked before the token is used up, so a password
+the policy refuses does not burn the link.
+
+Whoever knew the old password may still be logged in, so every session of
+the user is revoked and any login lockout is lifted. Following the link
+also proves the user owns the email.
+*/
+func (u *AuthUsecase) ResetPassword(ctx context.Context, req *models.ResetPasswordRequest, client *models.ClientInfo) error {
+	tokenHash := hashUserToken(req.Token)
+	userId, err := u.TokenRepo.FindUserToken(ctx, models.TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	user, err := u.UserRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	err = checkPassword(u.Policy, req.Password, user.Username, user.Email)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := u.Hasher.Hash(req.Password)
+	if err != nil {
+		return err
+	}
+
+	// Only one request can use the token, even if several got this far
+	consumedBy, err := u.TokenRepo.ConsumeUserToken(ctx, models.TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		return err
+	}
+	if consumedBy != userId {
+		return models.ErrInvalidUserToken
+	}
+
+	err = u.UserRepo.UpdatePassword(ctx, userId, string(hashedPassword))
+	if err != nil {
+		return err
+	}
+
+	err = u.UserRepo.MarkEmailVerified(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	err = u.TokenRepo.DeleteUserTokens(ctx, userId, models.TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	err = u.Lockout.UnblockLogin(ctx, models.LoginScopeEmail, models.NormalizeEmail(user.Email))
+	if err != nil {
+		return err
+	}
+
+	audit(ctx, u.Audit, &models.AuditEvent{
+		Type:   models.AuditPasswordReset,
+		UserID: &userId,
+		IP:     client.IP,
+	})
+
+	return u.LogoutAll(ctx, userId)
 }
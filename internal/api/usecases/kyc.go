@@ -0,0 +1,355 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/blob"
+	"github.com/bukharney/bank-core/internal/config"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/mailer"
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/google/uuid"
+)
+
+// maxKYCReviews is the most verifications listed for review at once
+const maxKYCReviews = 100
+
+// KYCUsecase is the usecase for the identity verification routes
+type KYCUsecase struct {
+	Cfg         *config.Config
+	Repo        models.KYCRepository
+	UserRepo    models.UserRepository
+	AccountRepo models.AccountRepository
+	Store       blob.Store
+	Mailer      mailer.Mailer
+	Audit       models.AuditRepository
+}
+
+// NewKYCUsecase creates a new KYCUsecase
+func NewKYCUsecase(cfg *config.Config, repo models.KYCRepository, userRepo models.UserRepository, accountRepo models.AccountRepository, store blob.Store, mail mailer.Mailer, audit models.AuditRepository) models.KYCUsecase {
+	return &KYCUsecase{
+		Cfg:         cfg,
+		Repo:        repo,
+		UserRepo:    userRepo,
+		AccountRepo: accountRepo,
+		Store:       store,
+		Mailer:      mail,
+		Audit:       audit,
+	}
+}
+
+// GetKYC gets the verification of a user with its documents
+func (u *KYCUsecase) GetKYC(ctx context.Context, userId string) (*models.KYC, error) {
+	if uuid.Validate(userId) != nil {
+		return nil, models.ErrKYCNotFound
+	}
+
+	kyc, err := u.Repo.GetKYC(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	kyc.Documents, err = u.Repo.GetDocuments(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return kyc, nil
+}
+
+/*
+UploadDocument stores a document of a user, replacing their earlier document
+of the same type.
+
+The type of the file is detected from its content rather than trusted from
+the client, and must be one of the configured content types.
+*/
+func (u *KYCUsecase) UploadDocument(ctx context.Context, userId string, documentType string, r io.Reader) (*models.KYCDocument, error) {
+	if !models.IsDocumentType(documentType) {
+		return nil, models.ErrUnknownDocumentType
+	}
+
+	// Checked again when the document is saved, this only avoids storing the file
+	kyc, err := u.Repo.GetKYC(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !kyc.CanUpload() {
+		return nil, models.ErrKYCDocumentsLocked
+	}
+
+	maxSize := u.Cfg.KYC.MaxDocumentSize
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, models.ErrDocumentTooLarge
+	}
+
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || len(data) == 0 || !slices.Contains(u.Cfg.KYC.ContentTypes, contentType) {
+		return nil, models.ErrDocumentContentType
+	}
+
+	id := uuid.New()
+	document := &models.KYCDocument{
+		ID:          id,
+		UserID:      kyc.UserID,
+		Type:        documentType,
+		BlobKey:     "kyc/" + kyc.UserID.String() + "/" + id.String(),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	err = u.Store.Put(ctx, document.BlobKey, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	replaced, err := u.Repo.SaveDocument(ctx, document)
+	if err != nil {
+		u.deleteBlob(ctx, document.BlobKey)
+		return nil, err
+	}
+
+	if replaced != "" {
+		u.deleteBlob(ctx, replaced)
+	}
+
+	return document, nil
+}
+
+// Submit sends the documents of a user to the compliance officers for review
+func (u *KYCUsecase) Submit(ctx context.Context, userId string, client *models.ClientInfo) (*models.KYC, error) {
+	kyc, err := u.GetKYC(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !kyc.CanTransition(models.KYCSubmitted) {
+		return nil, models.ErrKYCInvalidTransition
+	}
+
+	if !kyc.HasIdentityDocument() {
+		return nil, models.ErrKYCIdentityMissing
+	}
+
+	from := kyc.Status
+	now := time.Now().UTC()
+	kyc.Status = models.KYCSubmitted
+	kyc.SubmittedAt = &now
+	kyc.RejectionReason = nil
+
+	err = u.Repo.UpdateKYCStatus(ctx, kyc, from)
+	if err != nil {
+		return nil, err
+	}
+
+	audit(ctx, u.Audit, &models.AuditEvent{
+		Type:    models.AuditKYCSubmitted,
+		UserID:  &userId,
+		ActorID: &userId,
+		IP:      client.IP,
+	})
+
+	return kyc, nil
+}
+
+// RequireApproved returns ErrKYCNotApproved unless the identity of the user
+// was verified
+func (u *KYCUsecase) RequireApproved(ctx context.Context, userId string) error {
+	kyc, err := u.Repo.GetKYC(ctx, userId)
+	if err != nil {
+		if errors.Is(err, models.ErrKYCNotFound) {
+			return models.ErrKYCNotApproved
+		}
+		return err
+	}
+
+	if kyc.Status != models.KYCApproved {
+		return models.ErrKYCNotApproved
+	}
+
+	return nil
+}
+
+// ListReviews lists the verifications in a state, submitted ones by default
+func (u *KYCUsecase) ListReviews(ctx context.Context, status string) ([]*models.KYC, error) {
+	if status == "" {
+		status = models.KYCSubmitted
+	}
+
+	if !models.IsKYCStatus(status) {
+		return nil, models.ErrUnknownKYCStatus
+	}
+
+	return u.Repo.ListKYC(ctx, status, maxKYCReviews)
+}
+
+// OpenDocument opens the file of a document, the caller must close it
+func (u *KYCUsecase) OpenDocument(ctx context.Context, documentId string) (*models.KYCDocument, io.ReadCloser, error) {
+	if uuid.Validate(documentId) != nil {
+		return nil, nil, models.ErrDocumentNotFound
+	}
+
+	document, err := u.Repo.GetDocument(ctx, documentId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := u.Store.Get(ctx, document.BlobKey)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			return nil, nil, models.ErrDocumentNotFound
+		}
+		return nil, nil, err
+	}
+
+	return document, file, nil
+}
+
+/*
+Approve approves a submitted verification.
+
+Users used to get a savings account when they registered, so one is opened
+for them now if they have none. Failing to open it does not undo the
+approval, since the user can open an account themselves.
+*/
+func (u *KYCUsecase) Approve(ctx context.Context, reviewerId string, userId string, client *models.ClientInfo) (*models.KYC, error) {
+	kyc, err := u.decide(ctx, reviewerId, userId, models.KYCApproved, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.openDefaultAccount(ctx, userId)
+	if err != nil {
+		logger.WithContext(ctx).Errorw("failed to open account after KYC approval",
+			"user_id", userId,
+			"error", err,
+		)
+	}
+
+	u.notify(ctx, userId, mailer.KYCApprovedEmail)
+
+	audit(ctx, u.Audit, &models.AuditEvent{
+		Type:    models.AuditKYCApproved,
+		UserID:  &userId,
+		ActorID: &reviewerId,
+		IP:      client.IP,
+	})
+
+	return kyc, nil
+}
+
+// Reject rejects a submitted verification, the user can upload new documents
+// and submit again
+func (u *KYCUsecase) Reject(ctx context.Context, reviewerId string, userId string, reason string, client *models.ClientInfo) (*models.KYC, error) {
+	kyc, err := u.decide(ctx, reviewerId, userId, models.KYCRejected, &reason)
+	if err != nil {
+		return nil, err
+	}
+
+	u.notify(ctx, userId, func(to string) *mailer.Message {
+		return mailer.KYCRejectedEmail(to, reason)
+	})
+
+	audit(ctx, u.Audit, &models.AuditEvent{
+		Type:    models.AuditKYCRejected,
+		UserID:  &userId,
+		ActorID: &reviewerId,
+		IP:      client.IP,
+		Details: map[string]string{
+			"reason": reason,
+		},
+	})
+
+	return kyc, nil
+}
+
+// decide moves a submitted verification to the decision of a reviewer
+func (u *KYCUsecase) decide(ctx context.Context, reviewerId string, userId string, status string, reason *string) (*models.KYC, error) {
+	if reviewerId == userId {
+		return nil, models.ErrKYCOwnReview
+	}
+
+	reviewer, err := uuid.Parse(reviewerId)
+	if err != nil {
+		return nil, err
+	}
+
+	kyc, err := u.GetKYC(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !kyc.CanTransition(status) {
+		return nil, models.ErrKYCInvalidTransition
+	}
+
+	from := kyc.Status
+	now := time.Now().UTC()
+	kyc.Status = status
+	kyc.ReviewedAt = &now
+	kyc.ReviewerID = &reviewer
+	kyc.RejectionReason = reason
+
+	err = u.Repo.UpdateKYCStatus(ctx, kyc, from)
+	if err != nil {
+		return nil, err
+	}
+
+	return kyc, nil
+}
+
+// openDefaultAccount opens a savings account for a user who has no account
+func (u *KYCUsecase) openDefaultAccount(ctx context.Context, userId string) error {
+	accounts, err := u.AccountRepo.GetAccountsByUserID(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	if accounts != nil && len(*accounts) > 0 {
+		return nil
+	}
+
+	return u.AccountRepo.CreateAccount(ctx, &models.CreateAccountRequest{
+		UserID:      userId,
+		Balance:     money.FromMinor(0),
+		AccountType: "savings",
+	})
+}
+
+// notify emails a user the decision on their verification, in the background
+func (u *KYCUsecase) notify(ctx context.Context, userId string, message func(to string) *mailer.Message) {
+	user, err := u.UserRepo.GetUserById(ctx, userId)
+	if err != nil {
+		logger.WithContext(ctx).Errorw("failed to email KYC decision",
+			"user_id", userId,
+			"error", err,
+		)
+		return
+	}
+
+	sendInBackground(ctx, u.Mailer, message(user.Email))
+}
+
+// deleteBlob deletes a blob that is no longer referenced. Failures are only
+// logged, an orphaned blob is never served.
+func (u *KYCUsecase) deleteBlob(ctx context.Context, key string) {
+	err := u.Store.Delete(ctx, key)
+	if err != nil {
+		logger.WithContext(ctx).Errorw("failed to delete blob",
+			"key", key,
+			"error", err,
+		)
+	}
+}
@@ -0,0 +1,54 @@
+package usecases
+
+import (
+	"context"
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/money"
+)
+
+// LedgerUsecase is the usecase for the ledger routes
+type LedgerUsecase struct {
+	Cfg      *config.Config
+	Repo     models.LedgerRepository
+	UserRepo models.UserRepository
+}
+
+// NewLedgerUsecase creates a new LedgerUsecase
+func NewLedgerUsecase(cfg *config.Config, repo models.LedgerRepository, userRepo models.UserRepository) models.LedgerUsecase {
+	return &LedgerUsecase{
+		Cfg:      cfg,
+		Repo:     repo,
+		UserRepo: userRepo,
+	}
+}
+
+// GetTrialBalance gets the trial balance of the general ledger
+func (u *LedgerUsecase) GetTrialBalance(ctx context.Context, userID string) (*models.TrialBalance, error) {
+	lines, err := u.Repo.GetTrialBalance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	trialBalance := &models.TrialBalance{
+		Lines:       lines,
+		TotalDebit:  money.FromMinor(0),
+		TotalCredit: money.FromMinor(0),
+	}
+
+	for _, line := range lines {
+		trialBalance.TotalDebit, err = trialBalance.TotalDebit.Add(line.Debit)
+		if err != nil {
+			return nil, err
+		}
+
+		trialBalance.TotalCredit, err = trialBalance.TotalCredit.Add(line.Credit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	trialBalance.Balanced = trialBalance.TotalDebit.Cmp(trialBalance.TotalCredit) == 0
+
+	return trialBalance, nil
+}
@@ -0,0 +1,137 @@
+package usecases
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	logger "github.com/bukharney/bank-core/internal/logs"
+)
+
+// checkLoginBlocked returns an error if logins of the email or from the IP
+// are delayed or locked out
+func (u *AuthUsecase) checkLoginBlocked(ctx context.Context, email string, ip string) error {
+	subjects := []struct{ scope, subject string }{
+		{models.LoginScopeEmail, email},
+		{models.LoginScopeIP, ip},
+	}
+
+	for _, s := range subjects {
+		block, err := u.Lockout.GetLoginBlock(ctx, s.scope, s.subject)
+		if err != nil {
+			return err
+		}
+
+		if block != nil {
+			return block.Err()
+		}
+	}
+
+	return nil
+}
+
+/*
+loginFailed counts a failed login and returns the error to answer it with.
+
+The next login of the email is delayed by BaseDelay, doubling with each
+failure up to MaxDelay. The IP is not delayed, since many users can share
+one, but both are locked out once they reach their failure limit.
+user is nil if no user has the email.
+*/
+func (u *AuthUsecase) loginFailed(ctx context.Context, email string, ip string, user *models.User) error {
+	cfg := u.Cfg.Lockout
+
+	emailFailures, err := u.Lockout.RecordLoginFailure(ctx, models.LoginScopeEmail, email, cfg.Window)
+	if err != nil {
+		return err
+	}
+
+	ipFailures, err := u.Lockout.RecordLoginFailure(ctx, models.LoginScopeIP, ip, cfg.Window)
+	if err != nil {
+		return err
+	}
+
+	var userId *string
+	if user != nil {
+		id := user.ID.String()
+		userId = &id
+	}
+
+	if emailFailures >= int64(cfg.MaxEmailFailures) {
+		err = u.lockLogin(ctx, models.LoginScopeEmail, email, userId, ip, emailFailures)
+	} else {
+		err = u.Lockout.BlockLogin(ctx, models.LoginScopeEmail, email, &models.LoginBlock{
+			RetryAfter: loginDelay(cfg.BaseDelay, cfg.MaxDelay, emailFailures),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if ipFailures >= int64(cfg.MaxIPFailures) {
+		err = u.lockLogin(ctx, models.LoginScopeIP, ip, nil, ip, ipFailures)
+		if err != nil {
+			return err
+		}
+	}
+
+	return models.ErrInvalidCredentials
+}
+
+// lockLogin locks out the logins of a subject and records it in the audit trail
+func (u *AuthUsecase) lockLogin(ctx context.Context, scope string, subject string, userId *string, ip string, failures int64) error {
+	err := u.Lockout.BlockLogin(ctx, scope, subject, &models.LoginBlock{
+		Locked:     true,
+		RetryAfter: u.Cfg.Lockout.Duration,
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.WithContext(ctx).Warnw("too many failed logins, locking out",
+		"scope", scope,
+		"ip", ip,
+		"failures", failures,
+	)
+
+	audit(ctx, u.Audit, &models.AuditEvent{
+		Type:   models.AuditLoginLocked,
+		UserID: userId,
+		IP:     ip,
+		Details: map[string]string{
+			"scope":    scope,
+			"failures": strconv.FormatInt(failures, 10),
+			"duration": u.Cfg.Lockout.Duration.String(),
+		},
+	})
+
+	return nil
+}
+
+// audit records an event in the audit trail. A failure to record it is
+// logged rather than failing the request that caused it.
+func audit(ctx context.Context, repo models.AuditRepository, event *models.AuditEvent) {
+	err := repo.RecordEvent(ctx, event)
+	if err != nil {
+		logger.WithContext(ctx).Errorw("failed to record audit event",
+			"type", event.Type,
+			"error", err,
+		)
+	}
+}
+
+// loginDelay returns how long the next login is delayed after failures
+// failed logins: base, doubling with every failure, up to max
+func loginDelay(base time.Duration, max time.Duration, failures int64) time.Duration {
+	delay := base
+	for i := int64(1); i < failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		return max
+	}
+
+	return delay
+}
@@ -0,0 +1,368 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes issued when MFA is enabled
+	recoveryCodeCount = 10
+	// recoveryCodeSize is the size of a recovery code in bytes
+	recoveryCodeSize = 5
+	// mfaChallengeSize is the size of a login MFA challenge token in bytes
+	mfaChallengeSize = 32
+)
+
+// recoveryCodeEncoding encodes recovery codes so they are easy to type
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAUsecase is the usecase for multi-factor authentication
+type MFAUsecase struct {
+	Cfg      *config.Config
+	Repo     models.MFARepository
+	UserRepo models.UserRepository
+	Lockout  models.LockoutRepository
+	Sealer   *totp.Sealer
+}
+
+// NewMFAUsecase creates a new MFAUsecase
+func NewMFAUsecase(cfg *config.Config, repo models.MFARepository, userRepo models.UserRepository, lockout models.LockoutRepository, sealer *totp.Sealer) models.MFAUsecase {
+	return &MFAUsecase{
+		Cfg:      cfg,
+		Repo:     repo,
+		UserRepo: userRepo,
+		Lockout:  lockout,
+		Sealer:   sealer,
+	}
+}
+
+/*
+Enroll starts the MFA enrollment of a user with a new secret and returns
+the provisioning URI for their authenticator app.
+
+MFA is not enabled until a code is confirmed, so a user who loses the
+secret before confirming can simply enroll again.
+*/
+func (u *MFAUsecase) Enroll(ctx context.Context, userId string) (*models.MFAEnrollment, error) {
+	user, err := u.UserRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := u.Sealer.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.Repo.SaveMFASecret(ctx, userId, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.URI(u.Cfg.MFA.Issuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm enables MFA once the user proves their app generates valid codes,
+// and returns recovery codes that are only shown this once
+func (u *MFAUsecase) Confirm(ctx context.Context, userId string, code string) (*models.RecoveryCodes, error) {
+	mfa, err := u.Repo.GetMFA(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa.Enabled {
+		return nil, models.ErrMFAAlreadyEnabled
+	}
+
+	err = u.verifyTOTP(ctx, mfa, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(codes[i])), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = string(hash)
+	}
+
+	err = u.Repo.EnableMFA(ctx, userId, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithContext(ctx).Infow("enabled MFA", "user_id", userId)
+
+	return &models.RecoveryCodes{Codes: codes}, nil
+}
+
+// Disable turns off MFA for a user after checking a current code
+func (u *MFAUsecase) Disable(ctx context.Context, userId string, code string) error {
+	err := u.Verify(ctx, userId, code)
+	if err != nil {
+		if errors.Is(err, models.ErrMFAEnrollmentRequired) {
+			return models.ErrMFANotEnabled
+		}
+		return err
+	}
+
+	err = u.Repo.DisableMFA(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	logger.WithContext(ctx).Infow("disabled MFA", "user_id", userId)
+
+	return nil
+}
+
+// IsEnabled reports whether a user has enabled MFA
+func (u *MFAUsecase) IsEnabled(ctx context.Context, userId string) (bool, error) {
+	mfa, err := u.Repo.GetMFA(ctx, userId)
+	if err != nil {
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return mfa.Enabled, nil
+}
+
+/*
+Verify checks a TOTP or recovery code of a user who has enabled MFA.
+Each code is accepted once.
+
+Invalid codes are counted per user, whichever route they came from, and
+too many of them lock out the user's codes for the lockout duration.
+*/
+func (u *MFAUsecase) Verify(ctx context.Context, userId string, code string) error {
+	mfa, err := u.Repo.GetMFA(ctx, userId)
+	if err != nil {
+		if errors.Is(err, models.ErrMFANotEnrolled) {
+			return models.ErrMFAEnrollmentRequired
+		}
+		return err
+	}
+
+	if !mfa.Enabled {
+		return models.ErrMFAEnrollmentRequired
+	}
+
+	block, err := u.Lockout.GetLoginBlock(ctx, models.LoginScopeMFA, userId)
+	if err != nil {
+		return err
+	}
+	if block != nil {
+		return models.MFALockedError(block.RetryAfter)
+	}
+
+	err = u.verifyTOTP(ctx, mfa, code)
+	if errors.Is(err, models.ErrInvalidMFACode) {
+		err = u.useRecoveryCode(ctx, userId, code)
+	}
+	if errors.Is(err, models.ErrInvalidMFACode) {
+		return u.mfaFailed(ctx, userId)
+	}
+	if err != nil {
+		return err
+	}
+
+	return u.Lockout.ClearLoginFailures(ctx, models.LoginScopeMFA, userId)
+}
+
+// mfaFailed counts an invalid code of a user, locking out their codes once
+// they reach the failure limit, and returns the error to answer it with
+func (u *MFAUsecase) mfaFailed(ctx context.Context, userId string) error {
+	failures, err := u.Lockout.RecordLoginFailure(ctx, models.LoginScopeMFA, userId, u.Cfg.Lockout.Window)
+	if err != nil {
+		return err
+	}
+
+	if failures < int64(u.Cfg.MFA.MaxFailures) {
+		return models.ErrInvalidMFACode
+	}
+
+	err = u.Lockout.BlockLogin(ctx, models.LoginScopeMFA, userId, &models.LoginBlock{
+		Locked:     true,
+		RetryAfter: u.Cfg.Lockout.Duration,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = u.Lockout.ClearLoginFailures(ctx, models.LoginScopeMFA, userId)
+	if err != nil {
+		return err
+	}
+
+	logger.WithContext(ctx).Warnw("too many invalid MFA codes, locking out",
+		"user_id", userId,
+		"failures", failures,
+	)
+
+	return models.MFALockedError(u.Cfg.Lockout.Duration)
+}
+
+// StartChallenge starts the second step of a login and returns the token
+// the client completes it with
+func (u *MFAUsecase) StartChallenge(ctx context.Context, userId string) (string, error) {
+	b := make([]byte, mfaChallengeSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	err = u.Repo.CreateMFAChallenge(ctx, token, userId, u.Cfg.MFA.ChallengeTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+/*
+CompleteChallenge checks the code of a login MFA challenge and returns the
+user it was started for.
+
+A challenge is deleted once it succeeds or runs out of attempts, so a
+stolen password only buys a few guesses at the code.
+*/
+func (u *MFAUsecase) CompleteChallenge(ctx context.Context, token string, code string) (string, error) {
+	userId, attempts, err := u.Repo.AttemptMFAChallenge(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	if attempts > int64(u.Cfg.MFA.ChallengeAttempts) {
+		err = u.Repo.DeleteMFAChallenge(ctx, token)
+		if err != nil {
+			return "", err
+		}
+
+		logger.WithContext(ctx).Warnw("too many MFA attempts, ending login", "user_id", userId)
+		return "", models.ErrInvalidMFAChallenge
+	}
+
+	err = u.Verify(ctx, userId, code)
+	if err != nil {
+		return "", err
+	}
+
+	err = u.Repo.DeleteMFAChallenge(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// verifyTOTP checks a TOTP code and records its time step, so the code
+// cannot be replayed
+func (u *MFAUsecase) verifyTOTP(ctx context.Context, mfa *models.MFA, code string) error {
+	secret, err := u.Sealer.Open(mfa.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return models.ErrInvalidMFACode
+	}
+
+	ok, err = u.Repo.UseMFAStep(ctx, mfa.UserID, step)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return models.ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// useRecoveryCode checks code against the unused recovery codes of a user
+// and marks the one it matches as used
+func (u *MFAUsecase) useRecoveryCode(ctx context.Context, userId string, code string) error {
+	code = normalizeRecoveryCode(code)
+	if len(code) != recoveryCodeEncoding.EncodedLen(recoveryCodeSize)*2 {
+		return models.ErrInvalidMFACode
+	}
+
+	codes, err := u.Repo.GetRecoveryCodes(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, recoveryCode := range codes {
+		if bcrypt.CompareHashAndPassword([]byte(recoveryCode.CodeHash), []byte(code)) != nil {
+			continue
+		}
+
+		ok, err := u.Repo.UseRecoveryCode(ctx, recoveryCode.ID)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return models.ErrInvalidMFACode
+		}
+
+		logger.WithContext(ctx).Infow("used MFA recovery code",
+			"user_id", userId,
+			"remaining", len(codes)-1,
+		)
+		return nil
+	}
+
+	return models.ErrInvalidMFACode
+}
+
+// newRecoveryCode generates a recovery code such as abcde-fghij
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeSize*2)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+	return code[:len(code)/2] + "-" + code[len(code)/2:], nil
+}
+
+// normalizeRecoveryCode removes the separators and case a user may type a
+// recovery code with
+func normalizeRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return strings.ToLower(code)
+}
@@ -0,0 +1,148 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/totp"
+)
+
+// memoryMFA is an MFARepository kept in memory, with the enrollment of a
+// single user and no recovery codes
+type memoryMFA struct {
+	models.MFARepository
+
+	mu  sync.Mutex
+	mfa models.MFA
+}
+
+func (r *memoryMFA) GetMFA(ctx context.Context, userId string) (*models.MFA, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if userId != r.mfa.UserID {
+		return nil, models.ErrMFANotEnrolled
+	}
+
+	mfa := r.mfa
+	return &mfa, nil
+}
+
+// UseMFAStep mirrors the repository, which only moves last_step forward
+func (r *memoryMFA) UseMFAStep(ctx context.Context, userId string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if userId != r.mfa.UserID || step <= r.mfa.LastStep {
+		return false, nil
+	}
+
+	r.mfa.LastStep = step
+	return true, nil
+}
+
+func (r *memoryMFA) GetRecoveryCodes(ctx context.Context, userId string) ([]*models.RecoveryCode, error) {
+	return nil, nil
+}
+
+// countingLockout is a LockoutRepository that counts failures but never blocks
+type countingLockout struct {
+	models.LockoutRepository
+
+	mu       sync.Mutex
+	failures int64
+}
+
+func (l *countingLockout) GetLoginBlock(ctx context.Context, scope string, subject string) (*models.LoginBlock, error) {
+	return nil, nil
+}
+
+func (l *countingLockout) RecordLoginFailure(ctx context.Context, scope string, subject string, window time.Duration) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.failures++
+	return l.failures, nil
+}
+
+func (l *countingLockout) ClearLoginFailures(ctx context.Context, scope string, subject string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.failures = 0
+	return nil
+}
+
+// newTestMFA returns an MFAUsecase for a user who enabled MFA with secret
+func newTestMFA(t *testing.T, userId string) (*MFAUsecase, string) {
+	t.Helper()
+
+	cfg := config.NewConfig()
+	sealer, err := totp.NewSealer(cfg.MFA.EncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealer.Seal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &memoryMFA{mfa: models.MFA{UserID: userId, Secret: sealed, Enabled: true}}
+	u := NewMFAUsecase(cfg, repo, nil, &countingLockout{}, sealer).(*MFAUsecase)
+
+	return u, secret
+}
+
+// codeAt returns the code of secret for the time step offset steps from now
+func codeAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func TestVerifyRejectsReplayedCode(t *testing.T) {
+	ctx := context.Background()
+	u, secret := newTestMFA(t, "user")
+	code := codeAt(t, secret, 0)
+
+	err := u.Verify(ctx, "user", code)
+	if err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	err = u.Verify(ctx, "user", code)
+	if !errors.Is(err, models.ErrInvalidMFACode) {
+		t.Fatalf("replay: got %v, want %v", err, models.ErrInvalidMFACode)
+	}
+}
+
+func TestVerifyRejectsEarlierStep(t *testing.T) {
+	ctx := context.Background()
+	u, secret := newTestMFA(t, "user")
+
+	// A code of the next step is within the skew window
+	err := u.Verify(ctx, "user", codeAt(t, secret, 1))
+	if err != nil {
+		t.Fatalf("next step: %v", err)
+	}
+
+	err = u.Verify(ctx, "user", codeAt(t, secret, 0))
+	if !errors.Is(err, models.ErrInvalidMFACode) {
+		t.Fatalf("earlier step after a later one: got %v, want %v", err, models.ErrInvalidMFACode)
+	}
+}
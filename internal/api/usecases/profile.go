@@ -0,0 +1,217 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/apperr"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/mailer"
+)
+
+// minDateOfBirth is the earliest date of birth accepted
+var minDateOfBirth = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// GetProfile gets the profile of a user
+func (u *UserUsecase) GetProfile(ctx context.Context, userId string) (*models.Profile, error) {
+	user, err := u.Repo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.NewProfile(user), nil
+}
+
+// UpdateProfile updates the fields of a user's profile that are set in req
+func (u *UserUsecase) UpdateProfile(ctx context.Context, userId string, req *models.UpdateProfileRequest) (*models.Profile, error) {
+	user, err := u.Repo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.FirstName != nil {
+		user.FirstName = *req.FirstName
+	}
+	if req.LastName != nil {
+		user.LastName = *req.LastName
+	}
+	if req.Phone != nil {
+		user.Phone = optionalString(*req.Phone)
+	}
+	if req.Address != nil {
+		user.Address = optionalString(*req.Address)
+	}
+	if req.DateOfBirth != nil {
+		user.DateOfBirth, err = parseDateOfBirth(*req.DateOfBirth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = u.Repo.UpdateProfile(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.NewProfile(user), nil
+}
+
+/*
+ChangeEmail changes the email of a user after checking their password.
+
+The new email is unverified until the user follows the link sent to it,
+and the old email is told about the change in case the account was taken over.
+*/
+func (u *UserUsecase) ChangeEmail(ctx context.Context, userId string, req *models.ChangeEmailRequest, client *models.ClientInfo) (*models.Profile, error) {
+	user, err := u.Repo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.checkCurrentPassword(user, req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	email := models.NormalizeEmail(req.Email)
+	if email == user.Email {
+		return nil, models.ErrSameEmail
+	}
+
+	err = u.Repo.UpdateEmail(ctx, userId, email)
+	if err != nil {
+		return nil, err
+	}
+
+	// Links sent to the old email must not verify the new one
+	err = u.TokenRepo.DeleteUserTokens(ctx, userId, models.TokenPurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	oldEmail := user.Email
+	user.Email = email
+	user.EmailVerifiedAt = nil
+
+	err = u.sendVerificationEmail(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	sendInBackground(ctx, u.Mailer, mailer.EmailChangedEmail(oldEmail, email))
+
+	audit(ctx, u.Audit, &models.AuditEvent{
+		Type:    models.AuditEmailChanged,
+		UserID:  &userId,
+		ActorID: &userId,
+		IP:      client.IP,
+	})
+
+	return models.NewProfile(user), nil
+}
+
+/*
+ChangePassword changes the password of a user after checking the current one.
+
+Every other session of the user is revoked, so whoever knew the old password
+is logged out everywhere but on the device that changed it.
+*/
+func (u *UserUsecase) ChangePassword(ctx context.Context, userId string, sessionId string, req *models.ChangePasswordRequest, client *models.ClientInfo) error {
+	user, err := u.Repo.GetUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	err = u.checkCurrentPassword(user, req.CurrentPassword)
+	if err != nil {
+		return err
+	}
+
+	err = checkPassword(u.Policy, req.NewPassword, user.Username, user.Email)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := u.Hasher.Hash(req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	err = u.Repo.UpdatePassword(ctx, userId, hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := u.AuthRepo.ListSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == sessionId {
+			continue
+		}
+
+		err = u.AuthRepo.DeleteSession(ctx, session)
+		if err != nil {
+			return err
+		}
+		revoked++
+	}
+
+	logger.WithContext(ctx).Infow("changed password, revoked other sessions",
+		"user_id", userId,
+		"revoked", revoked,
+	)
+
+	sendInBackground(ctx, u.Mailer, mailer.PasswordChangedEmail(user.Email))
+
+	audit(ctx, u.Audit, &models.AuditEvent{
+		Type:    models.AuditPasswordChanged,
+		UserID:  &userId,
+		ActorID: &userId,
+		IP:      client.IP,
+	})
+
+	return nil
+}
+
+// checkCurrentPassword checks the password a user confirmed a change with
+func (u *UserUsecase) checkCurrentPassword(user *models.User, pw string) error {
+	ok, _, err := u.Hasher.Verify(user.Password, pw)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return models.ErrWrongPassword
+	}
+
+	return nil
+}
+
+// optionalString returns nil for an empty s, which clears the column
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
+
+// parseDateOfBirth parses a date of birth, or returns nil for an empty one
+func parseDateOfBirth(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	date, err := time.Parse(models.DateLayout, s)
+	if err != nil || date.Before(minDateOfBirth) || date.After(time.Now()) {
+		return nil, apperr.Validation("request validation failed", map[string]string{
+			"date_of_birth": "must be a date between 1900-01-01 and today",
+		})
+	}
+
+	return &date, nil
+}
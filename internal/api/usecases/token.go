@@ -0,0 +1,89 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/middleware"
+	"github.com/bukharney/bank-core/internal/api/models"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/mailer"
+)
+
+const (
+	// userTokenSize is the size of an emailed token in bytes
+	userTokenSize = 32
+	// mailSendTimeout bounds sending an email in the background
+	mailSendTimeout = 30 * time.Second
+)
+
+// issueUserToken creates a single-use token of the purpose for a user,
+// valid for ttl, and returns it. Only its hash is stored.
+func issueUserToken(ctx context.Context, repo models.UserTokenRepository, userId string, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, userTokenSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	err = repo.CreateUserToken(ctx, &models.UserToken{
+		TokenHash: hashUserToken(token),
+		UserID:    userId,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// hashUserToken returns the hash a user token is stored as
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenLink adds token to the query of link
+func tokenLink(link string, token string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+/*
+sendInBackground sends msg without making the request wait for the mail
+server, which can take longer than the request timeout.
+
+It also keeps responses from taking longer for existing users than for
+unknown emails. Failures are logged, since nobody is left to return them to.
+The send counts as in flight, so a graceful shutdown waits for it.
+*/
+func sendInBackground(ctx context.Context, m mailer.Mailer, msg *mailer.Message) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+	middleware.Go(ctx, func() {
+		defer cancel()
+
+		err := m.Send(ctx, msg)
+		if err != nil {
+			logger.WithContext(ctx).Errorw("failed to send email",
+				"subject", msg.Subject,
+				"error", err,
+			)
+		}
+	})
+}
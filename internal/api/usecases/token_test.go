@@ -0,0 +1,498 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/mailer"
+	"github.com/bukharney/bank-core/internal/password"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// testPassword meets the default password policy
+const testPassword = "correct horse battery"
+
+// tokenPattern finds the token in the link of an email
+var tokenPattern = regexp.MustCompile(`[?&]token=([A-Za-z0-9_-]+)`)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
+
+// memoryUsers is a UserRepository kept in memory
+type memoryUsers struct {
+	models.UserRepository
+
+	mu    sync.Mutex
+	users map[string]*models.User
+}
+
+func newMemoryUsers() *memoryUsers {
+	return &memoryUsers{users: map[string]*models.User{}}
+}
+
+func (r *memoryUsers) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			copy := *user
+			return &copy, nil
+		}
+	}
+
+	return nil, models.ErrUserNotFound
+}
+
+func (r *memoryUsers) GetUserById(ctx context.Context, id string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, models.ErrUserNotFound
+	}
+
+	copy := *user
+	return &copy, nil
+}
+
+func (r *memoryUsers) Register(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copy := *user
+	r.users[user.ID.String()] = &copy
+	return nil
+}
+
+func (r *memoryUsers) UpdatePassword(ctx context.Context, id string, password string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[id].Password = password
+	return nil
+}
+
+func (r *memoryUsers) MarkEmailVerified(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.users[id].EmailVerifiedAt = &now
+	return nil
+}
+
+// memoryUserTokens is a UserTokenRepository kept in memory, with a clock
+// tests can move forward
+type memoryUserTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*models.UserToken
+	now    func() time.Time
+}
+
+func newMemoryUserTokens() *memoryUserTokens {
+	return &memoryUserTokens{tokens: map[string]*models.UserToken{}, now: time.Now}
+}
+
+func (r *memoryUserTokens) CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (r *memoryUserTokens) FindUserToken(ctx context.Context, purpose string, tokenHash string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || !token.ExpiresAt.After(r.now()) {
+		return "", models.ErrInvalidUserToken
+	}
+
+	return token.UserID, nil
+}
+
+func (r *memoryUserTokens) ConsumeUserToken(ctx context.Context, purpose string, tokenHash string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || !token.ExpiresAt.After(r.now()) {
+		return "", models.ErrInvalidUserToken
+	}
+
+	delete(r.tokens, tokenHash)
+	return token.UserID, nil
+}
+
+func (r *memoryUserTokens) DeleteUserTokens(ctx context.Context, userId string, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, token := range r.tokens {
+		if token.UserID == userId && token.Purpose == purpose {
+			delete(r.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+// memorySessions is an AuthRepository that keeps sessions in memory
+type memorySessions struct {
+	models.AuthRepository
+
+	mu       sync.Mutex
+	sessions map[string]*models.Session
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{sessions: map[string]*models.Session{}}
+}
+
+func (r *memorySessions) CreateSession(ctx context.Context, session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[session.ID] = session
+	return nil
+}
+
+func (r *memorySessions) ListSessions(ctx context.Context, userId string) ([]*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := []*models.Session{}
+	for _, session := range r.sessions {
+		if session.UserID == userId {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (r *memorySessions) DeleteSession(ctx context.Context, session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, session.ID)
+	return nil
+}
+
+// noLockout is a LockoutRepository without any blocks
+type noLockout struct {
+	models.LockoutRepository
+}
+
+func (noLockout) UnblockLogin(ctx context.Context, scope string, subject string) error {
+	return nil
+}
+
+// discardAudit is an AuditRepository that drops every event
+type discardAudit struct{}
+
+func (discardAudit) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	return nil
+}
+
+// tokenFlow holds the usecases of the emailed token flows and their fakes
+type tokenFlow struct {
+	cfg      *config.Config
+	users    *memoryUsers
+	tokens   *memoryUserTokens
+	sessions *memorySessions
+	mail     *mailer.MemoryMailer
+	hasher   *password.Hasher
+	user     *UserUsecase
+	auth     *AuthUsecase
+
+	verifyToken string
+}
+
+func newTokenFlow(t *testing.T) *tokenFlow {
+	t.Helper()
+
+	cfg := config.NewConfig()
+	cfg.Password.BcryptCost = 4
+
+	policy, err := password.NewPolicy(cfg.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &tokenFlow{
+		cfg:      cfg,
+		users:    newMemoryUsers(),
+		tokens:   newMemoryUserTokens(),
+		sessions: newMemorySessions(),
+		mail:     mailer.NewMemoryMailer(),
+		hasher:   password.NewHasher(cfg.Password),
+	}
+	f.user = NewUserUsecase(cfg, f.users, nil, f.tokens, f.mail, f.hasher, policy, f.sessions, discardAudit{}).(*UserUsecase)
+	f.auth = NewAuthUsecase(cfg, f.sessions, f.users, nil, noLockout{}, discardAudit{}, f.tokens, f.mail, f.hasher, policy).(*AuthUsecase)
+
+	return f
+}
+
+// register registers a user and returns it, keeping the token of their
+// verification email in verifyToken
+func (f *tokenFlow) register(t *testing.T, email string) *models.User {
+	t.Helper()
+
+	err := f.user.Register(context.Background(), &models.RegisterRequest{
+		Username:  "jane" + uuid.NewString()[:8],
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     email,
+		Password:  testPassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := f.users.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.verifyToken = f.waitForToken(t, email, mailer.VerificationEmail("", "", 0).Subject)
+	return user
+}
+
+// waitForToken waits for an email with subject to reach to, since emails
+// are sent in the background, and returns the token in its link. The emails
+// sent so far are forgotten, so the next call waits for a new one.
+func (f *tokenFlow) waitForToken(t *testing.T, to string, subject string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		msgs := f.mail.Messages()
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if msgs[i].To != to || msgs[i].Subject != subject {
+				continue
+			}
+			f.mail.Reset()
+
+			match := tokenPattern.FindStringSubmatch(msgs[i].Body)
+			if match == nil {
+				t.Fatalf("email %q has no token link", subject)
+			}
+			return match[1]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("no %q email was sent to %s", subject, to)
+	return ""
+}
+
+func TestVerifyEmailTokenIsSingleUse(t *testing.T) {
+	f := newTokenFlow(t)
+	ctx := context.Background()
+	user := f.register(t, "jane@example.com")
+
+	token := f.verifyToken
+
+	err := f.user.VerifyEmail(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ = f.users.GetUserById(ctx, user.ID.String())
+	if user.EmailVerifiedAt == nil {
+		t.Error("email was not marked verified")
+	}
+
+	err = f.user.VerifyEmail(ctx, token)
+	if !errors.Is(err, models.ErrInvalidUserToken) {
+		t.Errorf("second use: got %v, want %v", err, models.ErrInvalidUserToken)
+	}
+}
+
+func TestVerifyEmailTokenExpires(t *testing.T) {
+	f := newTokenFlow(t)
+	user := f.register(t, "jane@example.com")
+
+	token := f.verifyToken
+	f.tokens.now = func() time.Time { return time.Now().Add(f.cfg.Mail.VerifyTTL + time.Minute) }
+
+	err := f.user.VerifyEmail(context.Background(), token)
+	if !errors.Is(err, models.ErrInvalidUserToken) {
+		t.Errorf("got %v, want %v", err, models.ErrInvalidUserToken)
+	}
+
+	user, _ = f.users.GetUserById(context.Background(), user.ID.String())
+	if user.EmailVerifiedAt != nil {
+		t.Error("email was verified with an expired token")
+	}
+}
+
+func TestResetPasswordTokenIsSingleUse(t *testing.T) {
+	f := newTokenFlow(t)
+	ctx := context.Background()
+	user := f.register(t, "jane@example.com")
+
+	err := f.auth.ForgotPassword(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := f.waitForToken(t, user.Email, mailer.PasswordResetEmail("", "", 0).Subject)
+
+	req := &models.ResetPasswordRequest{Token: token, Password: "a brand new passphrase"}
+	err = f.auth.ResetPassword(ctx, req, &models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ = f.users.GetUserById(ctx, user.ID.String())
+	ok, _, err := f.hasher.Verify(user.Password, req.Password)
+	if err != nil || !ok {
+		t.Errorf("password was not changed: %v", err)
+	}
+
+	req.Password = "yet another passphrase"
+	err = f.auth.ResetPassword(ctx, req, &models.ClientInfo{})
+	if !errors.Is(err, models.ErrInvalidUserToken) {
+		t.Errorf("second use: got %v, want %v", err, models.ErrInvalidUserToken)
+	}
+}
+
+func TestResetPasswordTokenExpires(t *testing.T) {
+	f := newTokenFlow(t)
+	ctx := context.Background()
+	user := f.register(t, "jane@example.com")
+
+	err := f.auth.ForgotPassword(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := f.waitForToken(t, user.Email, mailer.PasswordResetEmail("", "", 0).Subject)
+	f.tokens.now = func() time.Time { return time.Now().Add(f.cfg.Mail.ResetTTL + time.Minute) }
+
+	err = f.auth.ResetPassword(ctx, &models.ResetPasswordRequest{Token: token, Password: "a brand new passphrase"}, &models.ClientInfo{})
+	if !errors.Is(err, models.ErrInvalidUserToken) {
+		t.Fatalf("got %v, want %v", err, models.ErrInvalidUserToken)
+	}
+
+	user, _ = f.users.GetUserById(ctx, user.ID.String())
+	ok, _, _ := f.hasher.Verify(user.Password, testPassword)
+	if !ok {
+		t.Error("password was changed with an expired token")
+	}
+}
+
+func TestResetPasswordRefusedPasswordKeepsToken(t *testing.T) {
+	f := newTokenFlow(t)
+	ctx := context.Background()
+	user := f.register(t, "jane@example.com")
+
+	err := f.auth.ForgotPassword(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := f.waitForToken(t, user.Email, mailer.PasswordResetEmail("", "", 0).Subject)
+
+	err = f.auth.ResetPassword(ctx, &models.ResetPasswordRequest{Token: token, Password: "short"}, &models.ClientInfo{})
+	if err == nil || errors.Is(err, models.ErrInvalidUserToken) {
+		t.Fatalf("refused password: got %v, want a policy error", err)
+	}
+
+	err = f.auth.ResetPassword(ctx, &models.ResetPasswordRequest{Token: token, Password: "a brand new passphrase"}, &models.ClientInfo{})
+	if err != nil {
+		t.Errorf("token was used up by the refused password: %v", err)
+	}
+}
+
+func TestForgotPasswordOnlyLatestLinkWorks(t *testing.T) {
+	f := newTokenFlow(t)
+	ctx := context.Background()
+	user := f.register(t, "jane@example.com")
+	subject := mailer.PasswordResetEmail("", "", 0).Subject
+
+	err := f.auth.ForgotPassword(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := f.waitForToken(t, user.Email, subject)
+
+	err = f.auth.ForgotPassword(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := f.waitForToken(t, user.Email, subject)
+
+	err = f.auth.ResetPassword(ctx, &models.ResetPasswordRequest{Token: first, Password: "a brand new passphrase"}, &models.ClientInfo{})
+	if !errors.Is(err, models.ErrInvalidUserToken) {
+		t.Errorf("earlier link: got %v, want %v", err, models.ErrInvalidUserToken)
+	}
+
+	err = f.auth.ResetPassword(ctx, &models.ResetPasswordRequest{Token: latest, Password: "a brand new passphrase"}, &models.ClientInfo{})
+	if err != nil {
+		t.Errorf("latest link: %v", err)
+	}
+}
+
+func TestResetPasswordRevokesSessions(t *testing.T) {
+	f := newTokenFlow(t)
+	ctx := context.Background()
+	user := f.register(t, "jane@example.com")
+	other := f.register(t, "john@example.com")
+
+	for _, owner := range []*models.User{user, user, other} {
+		err := f.sessions.CreateSession(ctx, &models.Session{ID: uuid.NewString(), UserID: owner.ID.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := f.auth.ForgotPassword(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := f.waitForToken(t, user.Email, mailer.PasswordResetEmail("", "", 0).Subject)
+
+	err = f.auth.ResetPassword(ctx, &models.ResetPasswordRequest{Token: token, Password: "a brand new passphrase"}, &models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, _ := f.sessions.ListSessions(ctx, user.ID.String())
+	if len(sessions) != 0 {
+		t.Errorf("%d sessions survived the reset", len(sessions))
+	}
+
+	sessions, _ = f.sessions.ListSessions(ctx, other.ID.String())
+	if len(sessions) != 1 {
+		t.Errorf("other user has %d sessions, want 1", len(sessions))
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	f := newTokenFlow(t)
+
+	err := f.auth.ForgotPassword(context.Background(), "nobody@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if msgs := f.mail.Messages(); len(msgs) != 0 {
+		t.Errorf("%d emails were sent for an unknown email", len(msgs))
+	}
+}
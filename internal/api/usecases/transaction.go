@@ -2,6 +2,7 @@ package usecases
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,43 +10,69 @@ import (
 
 	"github.com/bukharney/bank-core/internal/api/models"
 	"github.com/bukharney/bank-core/internal/api/repositories"
+	"github.com/bukharney/bank-core/internal/apperr"
 	"github.com/bukharney/bank-core/internal/config"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/metrics"
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/bukharney/bank-core/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+const (
+	defaultTransactionPageSize = 20
+	maxTransactionPageSize     = 100
+)
+
+// errDispenseRefused marks an ATM answer that the cash was not dispensed
+var errDispenseRefused = errors.New("ATM refused to dispense")
+
 // TransactionUsecase is the usecase for the transaction routes
 type TransactionUsecase struct {
 	Cfg         *config.Config
 	Repo        *repositories.TransactionRepository
 	AccountRepo *repositories.AccountRepository
 	UserRepo    *repositories.UserRepository
+	MFA         models.MFAUsecase
+	KYC         models.KYCUsecase
 }
 
 // NewTransactionUsecase creates a new TransactionUsecase
-func NewTransactionUsecase(cfg *config.Config, repo *repositories.TransactionRepository, accountRepo *repositories.AccountRepository, userRepo *repositories.UserRepository) *TransactionUsecase {
+func NewTransactionUsecase(cfg *config.Config, repo *repositories.TransactionRepository, accountRepo *repositories.AccountRepository, userRepo *repositories.UserRepository, mfa models.MFAUsecase, kyc models.KYCUsecase) *TransactionUsecase {
 	return &TransactionUsecase{
 		Cfg:         cfg,
 		Repo:        repo,
 		AccountRepo: accountRepo,
 		UserRepo:    userRepo,
+		MFA:         mfa,
+		KYC:         kyc,
 	}
 }
 
-// Transfer transfers money from one account to another
-func (u *TransactionUsecase) Transfer(req *models.TransferRequest) error {
-	accounts, err := u.AccountRepo.GetAccountByID(req.FromAccountID)
+// Transfer transfers money from one account to another, once the identity
+// of the sender is verified
+func (u *TransactionUsecase) Transfer(ctx context.Context, req *models.TransferRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "TransactionUsecase.Transfer")
+	defer func() { tracing.End(span, err) }()
+
+	err = req.Amount.ValidatePositive()
 	if err != nil {
 		return err
 	}
 
-	if accounts.UserID.String() != req.UserID {
-		return errors.New("account does not belong to user")
+	err = u.KYC.RequireApproved(ctx, req.UserID)
+	if err != nil {
+		return err
 	}
 
-	if accounts.Balance < req.Amount {
-		return errors.New("insufficient funds")
+	err = u.checkStepUp(ctx, req)
+	if err != nil {
+		return err
 	}
 
-	err = u.Repo.Transfer(accounts.ID, req.ToAccountID, req.Amount)
+	// Ownership and funds are checked by the repository while the accounts are locked
+	err = u.Repo.Transfer(ctx, req.UserID, req.FromAccountID, req.ToAccountID, req.Amount)
+	metrics.ObserveTransaction(models.TransactionTypeTransfer, req.Amount, err)
 	if err != nil {
 		return err
 	}
@@ -53,18 +80,41 @@ func (u *TransactionUsecase) Transfer(req *models.TransferRequest) error {
 	return nil
 }
 
-// Deposit deposits money into an account
-func (u *TransactionUsecase) Deposit(req *models.DepositRequest) error {
-	atm, err := u.UserRepo.GetUserById(req.UserID)
+// checkStepUp requires an MFA code for transfers above the step-up amount
+func (u *TransactionUsecase) checkStepUp(ctx context.Context, req *models.TransferRequest) error {
+	if u.Cfg.MFA.StepUpAmount == "" {
+		return nil
+	}
+
+	// The amount is checked when the config is loaded
+	limit, err := money.Parse(u.Cfg.MFA.StepUpAmount)
 	if err != nil {
 		return err
 	}
 
-	if atm.Role != "atm" {
-		return errors.New("only ATMs can deposit money")
+	if req.Amount.Cmp(limit) <= 0 {
+		return nil
+	}
+
+	if req.MFACode == "" {
+		return models.ErrMFARequired
+	}
+
+	return u.MFA.Verify(ctx, req.UserID, req.MFACode)
+}
+
+// Deposit deposits money into an account
+func (u *TransactionUsecase) Deposit(ctx context.Context, req *models.DepositRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "TransactionUsecase.Deposit")
+	defer func() { tracing.End(span, err) }()
+
+	err = req.Amount.ValidatePositive()
+	if err != nil {
+		return err
 	}
 
-	err = u.Repo.Deposit(req.AccountID, req.Amount)
+	err = u.Repo.Deposit(ctx, req.AccountID, req.Amount)
+	metrics.ObserveTransaction(models.TransactionTypeDeposit, req.Amount, err)
 	if err != nil {
 		return err
 	}
@@ -73,31 +123,73 @@ func (u *TransactionUsecase) Deposit(req *models.DepositRequest) error {
 }
 
 // Withdraw withdraws money from an account
-func (u *TransactionUsecase) Withdrawal(req *models.WithdrawalRequest) error {
-	account, err := u.AccountRepo.GetAccountByID(req.AccountID)
+func (u *TransactionUsecase) Withdrawal(ctx context.Context, req *models.WithdrawalRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "TransactionUsecase.Withdrawal", attribute.Int("atm.id", req.ATMID))
+	defer func() { tracing.End(span, err) }()
+
+	err = req.Amount.ValidatePositive()
 	if err != nil {
 		return err
 	}
 
-	if account.UserID.String() != req.UserID {
-		return errors.New("account does not belong to user")
+	// ATMs can only dispense whole notes
+	if !req.Amount.IsWhole() {
+		return apperr.New(apperr.KindValidation, "amount_not_whole", "withdrawal amount must be a whole number")
 	}
 
-	if account.Balance < req.Amount {
-		return errors.New("insufficient funds")
+	transaction, err := u.Repo.Withdraw(ctx, req.UserID, req.AccountID, req.ATMID, req.Amount)
+	metrics.ObserveTransaction(models.TransactionTypeWithdraw, req.Amount, err)
+	if err != nil {
+		return err
 	}
 
-	err = u.Repo.Withdraw(account.ID, req.ATMID, req.Amount)
+	// The account was already debited, so the withdrawal is settled even if
+	// the request is cancelled from here on
+	settleCtx := context.WithoutCancel(ctx)
+
+	err = u.dispense(ctx, req)
+	if errors.Is(err, errDispenseRefused) {
+		reverseErr := u.Repo.ReverseWithdrawal(settleCtx, transaction)
+		if reverseErr != nil {
+			logger.WithContext(ctx).Errorw("failed to reverse withdrawal",
+				"transaction_id", transaction.ID,
+				"error", reverseErr,
+			)
+		}
+		return err
+	}
 	if err != nil {
+		// The ATM may still dispense after we stopped waiting, so only
+		// reconciliation with the ATM can tell whether to reverse
+		logger.WithContext(ctx).Warnw("withdrawal left pending for reconciliation",
+			"transaction_id", transaction.ID,
+			"atm_id", req.ATMID,
+			"error", err,
+		)
 		return err
 	}
 
+	err = u.Repo.UpdateTransactionStatus(settleCtx, transaction.ID, "success")
+	if err != nil {
+		// The cash was dispensed, the withdrawal stays pending for an operator
+		logger.WithContext(ctx).Errorw("failed to mark withdrawal successful",
+			"transaction_id", transaction.ID,
+			"error", err,
+		)
+	}
+
+	return nil
+}
+
+// dispense signals the ATM of a withdrawal to dispense the cash. The error
+// wraps errDispenseRefused only when the ATM answered that it did not.
+func (u *TransactionUsecase) dispense(ctx context.Context, req *models.WithdrawalRequest) error {
 	t := struct {
-		SessionID string  `json:"session_id"`
-		Amount    float64 `json:"amount"`
+		SessionID string `json:"session_id"`
+		Amount    int64  `json:"amount"`
 	}{
 		SessionID: req.SessionID,
-		Amount:    req.Amount,
+		Amount:    req.Amount.Major(),
 	}
 
 	jsonData, err := json.Marshal(t)
@@ -110,36 +202,38 @@ func (u *TransactionUsecase) Withdrawal(req *models.WithdrawalRequest) error {
 	// The ATM server is running on a separate port
 	// But in a real-world scenario, the ATM server would be running on a separate machine
 	// and the request would be sent over the network
-	res, err := http.Post(fmt.Sprintf("http://localhost:808%d/atm/dispense", req.ATMID), "application/json", bytes.NewBuffer(jsonData))
+	atmReq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.Cfg.ATM.URL(req.ATMID)+"/atm/dispense", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
+	atmReq.Header.Set("Content-Type", "application/json")
+	atmReq.Header.Set("X-Request-ID", logger.RequestID(ctx))
+
+	res, err := tracing.HTTPClient(u.Cfg.ATM.Timeout).Do(atmReq)
+	if err != nil {
+		metrics.ObserveATMDispenseFailure(req.ATMID)
+		logger.WithContext(ctx).Errorf("Could not reach ATM %d: %v", req.ATMID, err)
+		return apperr.Unavailable("withdrawal_pending", "the ATM did not confirm the withdrawal, it is pending review", err)
+	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return errors.New("could not send signal to ATM")
+		metrics.ObserveATMDispenseFailure(req.ATMID)
+		logger.WithContext(ctx).Errorf("ATM %d refused to dispense: status %d", req.ATMID, res.StatusCode)
+		return apperr.Unavailable("atm_unavailable", "could not send signal to ATM", fmt.Errorf("%w: status %d", errDispenseRefused, res.StatusCode))
 	}
 
 	return nil
 }
 
 // UpdateTransactionStatus updates the status of a transaction
-func (u *TransactionUsecase) UpdateTransactionStatus(req *models.UpdateTransactionStatusRequest) error {
-	user, err := u.UserRepo.GetUserById(req.UserID)
+func (u *TransactionUsecase) UpdateTransactionStatus(ctx context.Context, req *models.UpdateTransactionStatusRequest) error {
+	_, err := u.Repo.GetTransactionByID(ctx, req.ID)
 	if err != nil {
 		return err
 	}
 
-	if user.Role != "admin" {
-		return errors.New("unauthorized")
-	}
-
-	_, err = u.Repo.GetTransactionByID(req.ID)
-	if err != nil {
-		return err
-	}
-
-	err = u.Repo.UpdateTransactionStatus(req.ID, req.Status)
+	err = u.Repo.UpdateTransactionStatus(ctx, req.ID, req.Status)
 	if err != nil {
 		return err
 	}
@@ -148,11 +242,82 @@ func (u *TransactionUsecase) UpdateTransactionStatus(req *models.UpdateTransacti
 }
 
 // GetTransactionsByAccountID gets all transactions by account ID
-func (u *TransactionUsecase) GetTransactionsByAccountID(accountID int) ([]*models.Transaction, error) {
-	return u.Repo.GetTransactionsByAccountID(accountID)
+func (u *TransactionUsecase) GetTransactionsByAccountID(ctx context.Context, accountID int) ([]*models.Transaction, error) {
+	return u.Repo.GetTransactionsByAccountID(ctx, accountID)
 }
 
-// GetTransactionByID gets a transaction by ID
-func (u *TransactionUsecase) GetTransactionByID(id int) (*models.Transaction, error) {
-	return u.Repo.GetTransactionByID(id)
+// GetTransactionByID gets a transaction by ID if it involves an account of the user
+func (u *TransactionUsecase) GetTransactionByID(ctx context.Context, userID string, id int) (*models.Transaction, error) {
+	transaction, err := u.Repo.GetTransactionByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	accountIDs := []int{transaction.AccountID}
+	if transaction.ReceiverAccountID != nil && transaction.TransactionType == models.TransactionTypeTransfer {
+		accountIDs = append(accountIDs, *transaction.ReceiverAccountID)
+	}
+
+	for _, accountID := range accountIDs {
+		account, err := u.AccountRepo.GetAccountByID(ctx, accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		if account.UserID.String() == userID {
+			return transaction, nil
+		}
+	}
+
+	// Do not reveal that the transaction exists
+	return nil, models.ErrTransactionNotFound
+}
+
+// ListTransactions gets one page of the transaction history of a user
+func (u *TransactionUsecase) ListTransactions(ctx context.Context, filter *models.TransactionFilter) (*models.TransactionPage, error) {
+	if filter.AccountID != nil {
+		account, err := u.AccountRepo.GetAccountByID(ctx, *filter.AccountID)
+		if err != nil {
+			return nil, err
+		}
+
+		if account.UserID.String() != filter.UserID {
+			return nil, models.ErrAccountNotOwned
+		}
+	}
+
+	switch filter.Type {
+	case "", models.TransactionTypeTransfer, models.TransactionTypeDeposit, models.TransactionTypeWithdraw:
+	default:
+		return nil, apperr.BadRequest("invalid_filter", fmt.Sprintf("unknown transaction type %q", filter.Type))
+	}
+
+	if filter.MinAmount != nil && filter.MaxAmount != nil && filter.MinAmount.Cmp(*filter.MaxAmount) > 0 {
+		return nil, apperr.BadRequest("invalid_filter", "min_amount must not be greater than max_amount")
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultTransactionPageSize
+	}
+	if filter.Limit > maxTransactionPageSize {
+		filter.Limit = maxTransactionPageSize
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	transactions, err := u.Repo.ListTransactions(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.TransactionPage{Transactions: transactions}
+	if len(transactions) > limit {
+		page.Transactions = transactions[:limit]
+		last := page.Transactions[limit-1]
+		cursor := &models.TransactionCursor{Date: last.TransactionDate, ID: last.ID}
+		page.NextCursor = cursor.Encode()
+	}
+
+	return page, nil
 }
@@ -0,0 +1,67 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/money"
+)
+
+// dispenseWith sends a dispense signal to an ATM served by atm
+func dispenseWith(t *testing.T, atm http.HandlerFunc, timeout time.Duration) error {
+	t.Helper()
+
+	server := httptest.NewServer(atm)
+	defer server.Close()
+
+	cfg := config.NewConfig()
+	cfg.ATM.URLFormat = strings.ReplaceAll(server.URL, "%", "%%") + "/%d"
+	cfg.ATM.Timeout = timeout
+
+	u := &TransactionUsecase{Cfg: cfg}
+	return u.dispense(context.Background(), &models.WithdrawalRequest{
+		ATMID:     1,
+		SessionID: "session",
+		Amount:    money.FromMinor(10000),
+	})
+}
+
+func TestDispense(t *testing.T) {
+	t.Run("dispensed", func(t *testing.T) {
+		err := dispenseWith(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}, time.Second)
+		if err != nil {
+			t.Fatalf("dispense: %v", err)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		err := dispenseWith(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusConflict)
+		}, time.Second)
+		if !errors.Is(err, errDispenseRefused) {
+			t.Fatalf("got %v, want a refusal", err)
+		}
+	})
+
+	// The ATM may still dispense after the timeout, so it is no refusal
+	t.Run("timed out", func(t *testing.T) {
+		err := dispenseWith(t, func(w http.ResponseWriter, r *http.Request) {
+			// The context is only cancelled once the body was read
+			io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+		}, 50*time.Millisecond)
+		if err == nil || errors.Is(err, errDispenseRefused) {
+			t.Fatalf("got %v, want an unconfirmed dispense", err)
+		}
+	})
+}
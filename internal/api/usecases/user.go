@@ -1,13 +1,16 @@
 package usecases
 
 import (
-	"fmt"
-	"net/http"
+	"context"
+	"errors"
 
 	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/apperr"
 	"github.com/bukharney/bank-core/internal/config"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/mailer"
+	"github.com/bukharney/bank-core/internal/password"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // UserUsecase is the usecase for the user routes
@@ -15,19 +18,32 @@ type UserUsecase struct {
 	Cfg         *config.Config
 	Repo        models.UserRepository
 	AccountRepo models.AccountRepository
+	TokenRepo   models.UserTokenRepository
+	Mailer      mailer.Mailer
+	Hasher      *password.Hasher
+	Policy      *password.Policy
+	AuthRepo    models.AuthRepository
+	Audit       models.AuditRepository
 }
 
 // NewUserUsecase creates a new UserUsecase
-func NewUserUsecase(cfg *config.Config, repo models.UserRepository, accountRepo models.AccountRepository) models.UserUsecase {
+func NewUserUsecase(cfg *config.Config, repo models.UserRepository, accountRepo models.AccountRepository, tokenRepo models.UserTokenRepository, mail mailer.Mailer, hasher *password.Hasher, policy *password.Policy, authRepo models.AuthRepository, audit models.AuditRepository) models.UserUsecase {
 	return &UserUsecase{
-		Repo: repo,
-		Cfg:  cfg,
+		Repo:        repo,
+		Cfg:         cfg,
+		AccountRepo: accountRepo,
+		TokenRepo:   tokenRepo,
+		Mailer:      mail,
+		Hasher:      hasher,
+		Policy:      policy,
+		AuthRepo:    authRepo,
+		Audit:       audit,
 	}
 }
 
 // GetUser gets a user by email
-func (u *UserUsecase) GetUser(email string) (*models.User, error) {
-	user, err := u.Repo.GetUserByEmail(email)
+func (u *UserUsecase) GetUser(ctx context.Context, email string) (*models.User, error) {
+	user, err := u.Repo.GetUserByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
@@ -35,29 +51,91 @@ func (u *UserUsecase) GetUser(email string) (*models.User, error) {
 	return user, nil
 }
 
-// Register registers a new user
-func (u *UserUsecase) Register(user *models.User) (int, error) {
-	_, err := u.Repo.GetUserByEmail(user.Email)
+// Register registers a new user with the role user
+func (u *UserUsecase) Register(ctx context.Context, req *models.RegisterRequest) error {
+	req.Normalize()
+
+	err := checkPassword(u.Policy, req.Password, req.Username, req.Email)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.Repo.GetUserByEmail(ctx, req.Email)
 	if err == nil {
-		return http.StatusConflict, fmt.Errorf("user with email %s already exists", user.Email)
+		return models.ErrEmailTaken
+	}
+	if !errors.Is(err, models.ErrUserNotFound) {
+		return err
+	}
+
+	hashedPassword, err := u.Hasher.Hash(req.Password)
+	if err != nil {
+		return err
+	}
+
+	user := &models.User{
+		ID:        uuid.New(),
+		Role:      models.RoleUser,
+		Username:  req.Username,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Password:  hashedPassword,
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	// Accounts are opened once the identity of the user is verified
+	err = u.Repo.Register(ctx, user)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return err
 	}
 
-	user.ID = uuid.New()
-	user.Password = string(hashedPassword)
+	// The user exists now, a lost email should not fail the registration
+	err = u.sendVerificationEmail(ctx, user)
+	if err != nil {
+		logger.WithContext(ctx).Errorw("failed to create verification email",
+			"user_id", user.ID.String(),
+			"error", err,
+		)
+	}
+
+	return nil
+}
+
+// VerifyEmail verifies the email of the user a verification token was sent to
+func (u *UserUsecase) VerifyEmail(ctx context.Context, token string) error {
+	userId, err := u.TokenRepo.ConsumeUserToken(ctx, models.TokenPurposeEmailVerification, hashUserToken(token))
+	if err != nil {
+		return err
+	}
+
+	return u.Repo.MarkEmailVerified(ctx, userId)
+}
+
+// checkPassword checks a new password against the password policy
+func checkPassword(policy *password.Policy, pw string, personal ...string) error {
+	err := policy.Check(pw, personal...)
+	if err != nil {
+		return apperr.Validation("password does not meet the password policy", map[string]string{
+			"password": err.Error(),
+		})
+	}
+
+	return nil
+}
+
+// sendVerificationEmail emails a user the link to verify their email, in
+// the background
+func (u *UserUsecase) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := issueUserToken(ctx, u.TokenRepo, user.ID.String(), models.TokenPurposeEmailVerification, u.Cfg.Mail.VerifyTTL)
+	if err != nil {
+		return err
+	}
 
-	err = u.Repo.Register(user, &models.Account{
-		UserID:      user.ID,
-		Balance:     0,
-		AccountType: "savings",
-	})
+	link, err := tokenLink(u.Cfg.Mail.VerifyURL, token)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return err
 	}
 
-	return http.StatusCreated, nil
+	sendInBackground(ctx, u.Mailer, mailer.VerificationEmail(user.Email, link, u.Cfg.Mail.VerifyTTL))
+	return nil
 }
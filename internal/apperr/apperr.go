@@ -0,0 +1,195 @@
+package apperr
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Kind classifies an error and decides its HTTP status
+type Kind string
+
+// Kinds of errors returned by the API
+const (
+	KindBadRequest        Kind = "bad_request"
+	KindValidation        Kind = "validation_failed"
+	KindUnauthorized      Kind = "unauthorized"
+	KindForbidden         Kind = "forbidden"
+	KindNotFound          Kind = "not_found"
+	KindConflict          Kind = "conflict"
+	KindInsufficientFunds Kind = "insufficient_funds"
+	KindTooManyRequests   Kind = "too_many_requests"
+	KindTimeout           Kind = "timeout"
+	KindUnavailable       Kind = "unavailable"
+	KindInternal          Kind = "internal"
+)
+
+// statuses maps every kind to its HTTP status
+var statuses = map[Kind]int{
+	KindBadRequest:        http.StatusBadRequest,
+	KindValidation:        http.StatusUnprocessableEntity,
+	KindUnauthorized:      http.StatusUnauthorized,
+	KindForbidden:         http.StatusForbidden,
+	KindNotFound:          http.StatusNotFound,
+	KindConflict:          http.StatusConflict,
+	KindInsufficientFunds: http.StatusUnprocessableEntity,
+	KindTooManyRequests:   http.StatusTooManyRequests,
+	KindTimeout:           http.StatusServiceUnavailable,
+	KindUnavailable:       http.StatusServiceUnavailable,
+	KindInternal:          http.StatusInternalServerError,
+}
+
+/*
+Error is an error that can be shown to clients.
+
+Code is a stable, machine-readable identifier such as "account_not_found"
+that clients can branch on; it defaults to the kind. Detail is a human
+readable message and Fields holds per-field validation messages.
+Err is the underlying cause, which is logged but never sent to clients.
+RetryAfter, when set, tells clients how long to wait before retrying.
+*/
+type Error struct {
+	Kind       Kind
+	Code       string
+	Detail     string
+	Fields     map[string]string
+	RetryAfter time.Duration
+	Err        error
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+
+	return e.Detail
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status of the error
+func (e *Error) Status() int {
+	return Status(e.Kind)
+}
+
+// Status returns the HTTP status of kind
+func Status(kind Kind) int {
+	if status, ok := statuses[kind]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// New creates an error of kind with the given code and detail
+func New(kind Kind, code string, detail string) *Error {
+	if code == "" {
+		code = string(kind)
+	}
+
+	return &Error{Kind: kind, Code: code, Detail: detail}
+}
+
+// Wrap creates an error of kind with the given code and detail caused by err
+func Wrap(err error, kind Kind, code string, detail string) *Error {
+	e := New(kind, code, detail)
+	e.Err = err
+	return e
+}
+
+// As returns err as an *Error if it is one or wraps one
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+
+	return nil, false
+}
+
+// KindOf returns the kind of err, or KindInternal if it is not an *Error
+func KindOf(err error) Kind {
+	if e, ok := As(err); ok {
+		return e.Kind
+	}
+
+	return KindInternal
+}
+
+// IsKind reports whether err is an *Error of kind
+func IsKind(err error, kind Kind) bool {
+	e, ok := As(err)
+	return ok && e.Kind == kind
+}
+
+// Ensure returns err unchanged if it already is an *Error, and otherwise
+// wraps it as kind, using the message of err as the detail
+func Ensure(err error, kind Kind) *Error {
+	if e, ok := As(err); ok {
+		return e
+	}
+	if err == nil {
+		return New(kind, "", http.StatusText(Status(kind)))
+	}
+
+	return Wrap(err, kind, "", err.Error())
+}
+
+// BadRequest creates a KindBadRequest error
+func BadRequest(code string, detail string) *Error {
+	return New(KindBadRequest, code, detail)
+}
+
+// Validation creates a KindValidation error with per-field messages
+func Validation(detail string, fields map[string]string) *Error {
+	e := New(KindValidation, "", detail)
+	e.Fields = fields
+	return e
+}
+
+// Unauthorized creates a KindUnauthorized error
+func Unauthorized(code string, detail string) *Error {
+	return New(KindUnauthorized, code, detail)
+}
+
+// Forbidden creates a KindForbidden error
+func Forbidden(code string, detail string) *Error {
+	return New(KindForbidden, code, detail)
+}
+
+// NotFound creates a KindNotFound error
+func NotFound(code string, detail string) *Error {
+	return New(KindNotFound, code, detail)
+}
+
+// Conflict creates a KindConflict error
+func Conflict(code string, detail string) *Error {
+	return New(KindConflict, code, detail)
+}
+
+// InsufficientFunds creates a KindInsufficientFunds error
+func InsufficientFunds(detail string) *Error {
+	return New(KindInsufficientFunds, "", detail)
+}
+
+// TooManyRequests creates a KindTooManyRequests error that can be retried
+// after retryAfter
+func TooManyRequests(code string, detail string, retryAfter time.Duration) *Error {
+	e := New(KindTooManyRequests, code, detail)
+	e.RetryAfter = retryAfter
+	return e
+}
+
+// Unavailable creates a KindUnavailable error caused by err
+func Unavailable(code string, detail string, err error) *Error {
+	return Wrap(err, KindUnavailable, code, detail)
+}
+
+// Internal wraps an unexpected error. Its cause is logged, not shown.
+func Internal(err error) *Error {
+	return Wrap(err, KindInternal, "", "internal server error")
+}
@@ -0,0 +1,35 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bukharney/bank-core/internal/config"
+)
+
+// ErrNotFound is returned for keys that have no blob
+var ErrNotFound = errors.New("blob not found")
+
+/*
+Store keeps files such as identity documents outside the database.
+
+Keys are chosen by the application and may contain slashes to group blobs,
+e.g. kyc/<user id>/<document id>. Put overwrites an existing blob.
+*/
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New creates the store of the configured driver
+func New(cfg config.Blob) (Store, error) {
+	switch cfg.Driver {
+	case config.BlobDriverLocal:
+		return NewLocalStore(cfg.Dir)
+	default:
+		return nil, fmt.Errorf("unknown blob driver %q", cfg.Driver)
+	}
+}
@@ -0,0 +1,445 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of every environment variable read by Load
+const envPrefix = "BANK_"
+
+// redacted replaces secret values in Redacted
+const redacted = "[REDACTED]"
+
+// weakSecrets are values that must never be used in production
+var weakSecrets = map[string]bool{
+	"":         true,
+	"access":   true,
+	"refresh":  true,
+	"root":     true,
+	"secret":   true,
+	"password": true,
+	"postgres": true,
+	"changeme": true,
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// dsnPasswordKeys are the connection parameters holding a password
+var dsnPasswordKeys = []string{"password", "sslpassword"}
+
+// dsnPasswordPattern matches a password in a keyword/value DSN such as
+// "host=db password=secret", with the value bare or single-quoted
+var dsnPasswordPattern = regexp.MustCompile(`\b((?:ssl)?password)\s*=\s*(?:'(?:[^'\\]|\\.)*'|\S*)`)
+
+/*
+Load builds the configuration in order of increasing precedence:
+the development defaults, the YAML file at path (skipped when path is empty),
+then BANK_* environment variables and BANK_*_FILE secret files.
+The result is validated before it is returned.
+*/
+func Load(path string) (*Config, error) {
+	cfg := NewConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	err := applyEnv(reflect.ValueOf(cfg).Elem(), envPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// applyEnv walks the config struct and sets every field that has a matching
+// environment variable, or for secrets a matching _FILE variable
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		key := prefix + strings.ToUpper(name)
+		value := v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			err := applyEnv(value, key+"_")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := os.LookupEnv(key)
+		if file, isSet := os.LookupEnv(key + "_FILE"); isSet && field.Tag.Get("secret") != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", key, err)
+			}
+			raw, ok = strings.TrimSpace(string(data)), true
+		}
+		if !ok {
+			continue
+		}
+
+		err := setValue(value, raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// setValue parses raw into the field according to its type
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// Validate checks the configuration and refuses weak secrets and generated keys in production
+func (c *Config) Validate() error {
+	errs := []error{}
+
+	switch c.Env {
+	case EnvDevelopment, EnvProduction, EnvTest:
+	default:
+		errs = append(errs, fmt.Errorf("env must be one of %s, %s, %s", EnvDevelopment, EnvProduction, EnvTest))
+	}
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if c.DB.URL == "" {
+		errs = append(errs, errors.New("db.url is required"))
+	}
+	if c.Redis.URL == "" {
+		errs = append(errs, errors.New("redis.url is required"))
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.request_timeout", c.Server.RequestTimeout},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"startup.initial_backoff", c.Startup.InitialBackoff},
+		{"startup.max_backoff", c.Startup.MaxBackoff},
+		{"jwt.access_ttl", c.JWT.AccessTTL},
+		{"jwt.refresh_ttl", c.JWT.RefreshTTL},
+		{"idempotency.ttl", c.Idempotency.TTL},
+		{"idempotency.lock_ttl", c.Idempotency.LockTTL},
+		{"atm.timeout", c.ATM.Timeout},
+		{"health.check_timeout", c.Health.CheckTimeout},
+		{"mfa.challenge_ttl", c.MFA.ChallengeTTL},
+		{"lockout.window", c.Lockout.Window},
+		{"lockout.duration", c.Lockout.Duration},
+		{"lockout.base_delay", c.Lockout.BaseDelay},
+		{"lockout.max_delay", c.Lockout.MaxDelay},
+		{"mail.verify_ttl", c.Mail.VerifyTTL},
+		{"mail.reset_ttl", c.Mail.ResetTTL},
+		{"kyc.transfer_timeout", c.KYC.TransferTimeout},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", d.name))
+		}
+	}
+
+	if c.Server.WriteTimeout <= c.Server.RequestTimeout {
+		errs = append(errs, errors.New("server.write_timeout must be longer than server.request_timeout"))
+	}
+	if c.Health.CheckTimeout >= c.Server.RequestTimeout {
+		errs = append(errs, errors.New("health.check_timeout must be shorter than server.request_timeout"))
+	}
+	if c.Startup.MaxAttempts < 1 {
+		errs = append(errs, errors.New("startup.max_attempts must be at least 1"))
+	}
+
+	switch c.Tracing.Exporter {
+	case TracingExporterNone, TracingExporterStdout, TracingExporterOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be one of %s, %s, %s", TracingExporterNone, TracingExporterStdout, TracingExporterOTLP))
+	}
+	if c.Tracing.Exporter == TracingExporterOTLP && c.Tracing.Endpoint == "" {
+		errs = append(errs, errors.New("tracing.endpoint is required for the otlp exporter"))
+	}
+	if c.Tracing.ServiceName == "" {
+		errs = append(errs, errors.New("tracing.service_name is required"))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+
+	if c.JWT.Issuer == "" || c.JWT.Audience == "" {
+		errs = append(errs, errors.New("jwt.issuer and jwt.audience are required"))
+	}
+	if c.JWT.KeysDir != "" && c.JWT.SigningKeyID == "" {
+		errs = append(errs, errors.New("jwt.signing_key_id is required with jwt.keys_dir"))
+	}
+
+	if c.MFA.Issuer == "" {
+		errs = append(errs, errors.New("mfa.issuer is required"))
+	}
+	if c.MFA.ChallengeAttempts < 1 {
+		errs = append(errs, errors.New("mfa.challenge_attempts must be at least 1"))
+	}
+	if c.MFA.MaxFailures < 1 {
+		errs = append(errs, errors.New("mfa.max_failures must be at least 1"))
+	}
+	if key, err := base64.StdEncoding.DecodeString(c.MFA.EncryptionKey); err != nil || len(key) != 32 {
+		errs = append(errs, errors.New("mfa.encryption_key must be 32 bytes, base64 encoded"))
+	}
+	if c.MFA.StepUpAmount != "" {
+		amount, err := money.Parse(c.MFA.StepUpAmount)
+		if err != nil || !amount.IsPositive() {
+			errs = append(errs, errors.New("mfa.step_up_amount must be a positive amount"))
+		}
+	}
+
+	if c.Lockout.MaxEmailFailures < 1 || c.Lockout.MaxIPFailures < 1 {
+		errs = append(errs, errors.New("lockout.max_email_failures and lockout.max_ip_failures must be at least 1"))
+	}
+	if c.Lockout.BaseDelay > c.Lockout.MaxDelay {
+		errs = append(errs, errors.New("lockout.base_delay must not be longer than lockout.max_delay"))
+	}
+
+	switch c.Mail.Driver {
+	case MailDriverSMTP:
+		if c.Mail.SMTP.Host == "" || c.Mail.SMTP.Port <= 0 {
+			errs = append(errs, errors.New("mail.smtp.host and mail.smtp.port are required for the smtp driver"))
+		}
+	case MailDriverFile:
+		if c.Mail.Dir == "" {
+			errs = append(errs, errors.New("mail.dir is required for the file driver"))
+		}
+	case MailDriverMemory:
+	default:
+		errs = append(errs, fmt.Errorf("mail.driver must be one of %s, %s, %s", MailDriverSMTP, MailDriverFile, MailDriverMemory))
+	}
+	if c.Mail.From == "" {
+		errs = append(errs, errors.New("mail.from is required"))
+	}
+	for _, link := range []struct{ name, value string }{
+		{"mail.verify_url", c.Mail.VerifyURL},
+		{"mail.reset_url", c.Mail.ResetURL},
+	} {
+		u, err := url.Parse(link.value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be an absolute URL", link.name))
+		}
+	}
+
+	if c.Password.MinLength < 8 {
+		errs = append(errs, errors.New("password.min_length must be at least 8"))
+	}
+	if c.Password.MaxLength < c.Password.MinLength {
+		errs = append(errs, errors.New("password.max_length must not be less than password.min_length"))
+	}
+	switch c.Password.Hasher {
+	case PasswordHasherBcrypt:
+		if c.Password.BcryptCost < 10 || c.Password.BcryptCost > 31 {
+			errs = append(errs, errors.New("password.bcrypt_cost must be between 10 and 31"))
+		}
+		if c.Password.MaxLength > 72 {
+			errs = append(errs, errors.New("password.max_length must be at most 72 with bcrypt"))
+		}
+	case PasswordHasherArgon2id:
+		if c.Password.Argon2MemoryKiB < 19*1024 || c.Password.Argon2Iterations < 1 || c.Password.Argon2Parallelism < 1 || c.Password.Argon2Parallelism > 255 {
+			errs = append(errs, errors.New("password.argon2_memory_kib must be at least 19456, argon2_iterations at least 1 and argon2_parallelism between 1 and 255"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("password.hasher must be one of %s, %s", PasswordHasherBcrypt, PasswordHasherArgon2id))
+	}
+
+	switch c.Blob.Driver {
+	case BlobDriverLocal:
+		if c.Blob.Dir == "" {
+			errs = append(errs, errors.New("blob.dir is required for the local driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("blob.driver must be %s", BlobDriverLocal))
+	}
+	if c.KYC.MaxDocumentSize <= 0 {
+		errs = append(errs, errors.New("kyc.max_document_size must be positive"))
+	}
+	if len(c.KYC.ContentTypes) == 0 {
+		errs = append(errs, errors.New("kyc.content_types must not be empty"))
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("cors.allowed_origins: %q must be an origin such as https://app.example.com", origin))
+		}
+	}
+
+	if c.IsProduction() {
+		if c.JWT.KeysDir == "" {
+			errs = append(errs, errors.New("jwt.keys_dir is required in production"))
+		}
+		if c.MFA.EncryptionKey == DevelopmentMFAKey {
+			errs = append(errs, errors.New("mfa.encryption_key must not be the development key in production"))
+		}
+		if c.Mail.Driver != MailDriverSMTP {
+			errs = append(errs, errors.New("mail.driver must be smtp in production"))
+		}
+
+		if weakSecrets[strings.ToLower(c.Redis.Password)] {
+			errs = append(errs, errors.New("redis.password must not be empty or a default value in production"))
+		}
+
+		// pgconn reads the password from every form of DSN: the URL user
+		// info, a password query parameter or a password=... keyword
+		dsn, err := pgconn.ParseConfig(c.DB.URL)
+		if err == nil && weakSecrets[strings.ToLower(dsn.Password)] {
+			errs = append(errs, errors.New("db.url must not use an empty or default password in production"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the configuration with every secret masked,
+// suitable for printing or logging
+func (c *Config) Redacted() *Config {
+	cp := *c
+	redact(reflect.ValueOf(&cp).Elem())
+	return &cp
+}
+
+// redact masks the fields tagged secret in place
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			redact(value)
+			continue
+		}
+
+		switch field.Tag.Get("secret") {
+		case "true":
+			if value.Kind() == reflect.String && value.String() != "" {
+				value.SetString(redacted)
+			}
+		case "url":
+			value.SetString(redactDSN(value.String()))
+		}
+	}
+}
+
+// redactDSN masks the passwords of a postgres connection string, keeping the
+// host and database visible. It handles both the URL form, where they can be
+// in the user info or the query, and the keyword/value form.
+func redactDSN(dsn string) string {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsnPasswordPattern.ReplaceAllString(dsn, "${1}="+redacted)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return redacted
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	query := u.Query()
+	for _, key := range dsnPasswordKeys {
+		if query.Has(key) {
+			query.Set(key, redacted)
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	return strings.ReplaceAll(u.String(), url.QueryEscape(redacted), redacted)
+}
+
+// YAML encodes the configuration as YAML
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// weakDBPassword is the error Validate reports for a weak db.url password
+const weakDBPassword = "db.url must not use an empty or default password"
+
+// dsns are connection strings carrying the password hunter2 in every form
+var dsns = map[string]string{
+	"url user info":   "postgres://bank:hunter2@db:5432/bank?sslmode=disable",
+	"url query":       "postgres://bank@db:5432/bank?sslmode=disable&password=hunter2",
+	"keyword":         "host=db port=5432 user=bank password=hunter2 dbname=bank",
+	"quoted keyword":  "host=db user=bank password='hunter2 \\'quoted\\'' dbname=bank",
+	"spaced keyword":  "host=db user=bank password = hunter2 dbname=bank",
+	"ssl password":    "host=db user=bank sslpassword=hunter2 dbname=bank",
+	"ssl url query":   "postgresql://bank@db/bank?sslpassword=hunter2",
+	"both in the url": "postgres://bank:hunter2@db/bank?password=hunter2",
+}
+
+func TestRedactedDSN(t *testing.T) {
+	for name, dsn := range dsns {
+		t.Run(name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.DB.URL = dsn
+
+			got := cfg.Redacted().DB.URL
+			if strings.Contains(got, "hunter2") {
+				t.Errorf("password not masked: %s", got)
+			}
+			if !strings.Contains(got, redacted) {
+				t.Errorf("no %s marker: %s", redacted, got)
+			}
+			if !strings.Contains(got, "db") || !strings.Contains(got, "bank") {
+				t.Errorf("host or database masked too: %s", got)
+			}
+		})
+	}
+
+	cfg := NewConfig()
+	cfg.DB.URL = "postgres://bank@db/bank?sslmode=disable"
+	if got := cfg.Redacted().DB.URL; got != cfg.DB.URL {
+		t.Errorf("url without a password changed to %s", got)
+	}
+}
+
+func TestValidateWeakDBPassword(t *testing.T) {
+	weak := map[string]string{
+		"url user info": "postgres://bank:postgres@db/bank",
+		"url query":     "postgres://bank@db/bank?password=postgres",
+		"keyword":       "host=db user=bank password=postgres dbname=bank",
+		"no password":   "host=db user=bank dbname=bank",
+	}
+
+	for name, dsn := range weak {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("PGPASSWORD", "")
+			t.Setenv("PGPASSFILE", "/nonexistent")
+
+			cfg := NewConfig()
+			cfg.Env = EnvProduction
+			cfg.DB.URL = dsn
+
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), weakDBPassword) {
+				t.Errorf("weak password accepted: %v", err)
+			}
+		})
+	}
+
+	for name, dsn := range dsns {
+		// sslpassword unlocks the client key, it is not the login password
+		if strings.HasPrefix(name, "ssl") {
+			continue
+		}
+
+		t.Run("strong "+name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Env = EnvProduction
+			cfg.DB.URL = dsn
+
+			err := cfg.Validate()
+			if err != nil && strings.Contains(err.Error(), weakDBPassword) {
+				t.Errorf("strong password rejected: %v", err)
+			}
+		})
+	}
+}
@@ -2,66 +2,61 @@ package db
 
 import (
 	"context"
-	"fmt"
-	"os"
-	"strings"
+	"database/sql/driver"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/bukharney/bank-core/internal/config"
 	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/bukharney/bank-core/internal/tracing"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Connect connects to the database
-func Connect(cfg *config.Config) (*sqlx.DB, error) {
-	db, err := sqlx.Connect("pgx", cfg.DB.URL)
+func Connect(ctx context.Context, cfg *config.Config) (*sqlx.DB, error) {
+	// Queries are traced when they run with a context carrying a span,
+	// queries without one would only create disconnected root spans
+	sqlDB, err := otelsql.Open("pgx", cfg.DB.URL,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}))
 	if err != nil {
 		return nil, err
 	}
 
-	// err = Migrate(db)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	logger.Logger.Infoln("Connected to the postgres")
-	return db, nil
-}
-
-// Migrate migrates the database
-func Migrate(db *sqlx.DB) error {
-	files, err := os.ReadDir("./internal/db/migrations")
+	db := sqlx.NewDb(sqlDB, "pgx")
+	err = db.PingContext(ctx)
 	if err != nil {
-		return err
-	}
-
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".sql") {
-			migration, err := os.ReadFile(fmt.Sprintf("./internal/db/migrations/%s", file.Name()))
-			if err != nil {
-				return err
-			}
-
-			_, err = db.Exec(string(migration))
-			if err != nil {
-				return err
-			}
-		}
+		db.Close()
+		return nil, err
 	}
 
-	return nil
+	logger.Logger.Infoln("Connected to the postgres")
+	return db, nil
 }
 
-func RedisConnect(cfg *config.Config) (*redis.Client, error) {
+// RedisConnect connects to redis
+func RedisConnect(ctx context.Context, cfg *config.Config) (*redis.Client, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     cfg.Redis.URL,
 		Password: cfg.Redis.Password,
 		DB:       cfg.Redis.DB,
 	})
 
-	_, err := client.Ping(context.Background()).Result()
+	client.AddHook(tracing.RedisHook())
+
+	_, err := client.Ping(ctx).Result()
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 
@@ -69,3 +64,54 @@ func RedisConnect(cfg *config.Config) (*redis.Client, error) {
 
 	return client, nil
 }
+
+// Retry calls fn until it succeeds, ctx is done or cfg.Startup.MaxAttempts
+// is reached, doubling the wait between attempts up to cfg.Startup.MaxBackoff
+func Retry(ctx context.Context, cfg *config.Config, name string, fn func(ctx context.Context) error) error {
+	backoff := cfg.Startup.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= cfg.Startup.MaxAttempts {
+			return err
+		}
+
+		logger.Logger.Warnf("Could not connect to %s (attempt %d/%d), retrying in %s: %v", name, attempt, cfg.Startup.MaxAttempts, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, cfg.Startup.MaxBackoff)
+	}
+}
+
+// ConnectWithRetry connects to the database, retrying with backoff
+func ConnectWithRetry(ctx context.Context, cfg *config.Config) (*sqlx.DB, error) {
+	var db *sqlx.DB
+	err := Retry(ctx, cfg, "postgres", func(ctx context.Context) error {
+		var err error
+		db, err = Connect(ctx, cfg)
+		return err
+	})
+
+	return db, err
+}
+
+// RedisConnectWithRetry connects to redis, retrying with backoff
+func RedisConnectWithRetry(ctx context.Context, cfg *config.Config) (*redis.Client, error) {
+	var client *redis.Client
+	err := Retry(ctx, cfg, "redis", func(ctx context.Context) error {
+		var err error
+		client, err = RedisConnect(ctx, cfg)
+		return err
+	})
+
+	return client, err
+}
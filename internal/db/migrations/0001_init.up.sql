@@ -1,5 +1,8 @@
+-- Databases created by the old init.sql already have these tables, IF NOT
+-- EXISTS lets the first migration adopt them instead of failing
+
 -- Create a table for storing user information
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY,
     role VARCHAR(50) DEFAULT 'user',
     username VARCHAR(50) NOT NULL,
@@ -11,7 +14,7 @@ CREATE TABLE users (
 );
 
 -- Create a table for storing account information
-CREATE TABLE accounts (
+CREATE TABLE IF NOT EXISTS accounts (
     id SERIAL PRIMARY KEY,
     user_id UUID REFERENCES users(id),
     account_type VARCHAR(50) NOT NULL,
@@ -20,7 +23,7 @@ CREATE TABLE accounts (
 );
 
 -- Create a table for storing transaction information
-CREATE TABLE transactions (
+CREATE TABLE IF NOT EXISTS transactions (
     id SERIAL PRIMARY KEY,
     account_id INTEGER REFERENCES accounts(id) NOT NULL,
     receiver_account_id INTEGER REFERENCES accounts(id) NULL,
@@ -29,4 +32,4 @@ CREATE TABLE transactions (
     transaction_reference VARCHAR(50) NOT NULL,
     transaction_status VARCHAR(50) NOT NULL,
     transaction_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
@@ -0,0 +1,81 @@
+-- Create a table for storing the accounts of the general ledger.
+-- Customer accounts get a liability ledger account linked through account_id,
+-- system accounts (cash, ATM cash in transit, fee income) are identified by code.
+CREATE TABLE ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) UNIQUE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    ledger_type VARCHAR(20) NOT NULL CHECK (ledger_type IN ('asset', 'liability', 'equity', 'income', 'expense')),
+    account_id INTEGER UNIQUE REFERENCES accounts(id) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create a table for storing journal entries, one per money movement
+CREATE TABLE journal_entries (
+    id SERIAL PRIMARY KEY,
+    transaction_id INTEGER REFERENCES transactions(id) NULL,
+    description VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create a table for storing journal lines.
+-- Debits are positive and credits are negative, so every entry sums to zero.
+CREATE TABLE journal_lines (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER REFERENCES journal_entries(id) NOT NULL,
+    ledger_account_id INTEGER REFERENCES ledger_accounts(id) NOT NULL,
+    amount DECIMAL(15, 2) NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX journal_lines_entry_id_idx ON journal_lines (entry_id);
+CREATE INDEX journal_lines_ledger_account_id_idx ON journal_lines (ledger_account_id);
+
+-- Reject any journal entry whose lines do not sum to zero at commit time
+CREATE FUNCTION check_journal_entry_balanced() RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM journal_lines WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER journal_entry_balanced
+    AFTER INSERT OR UPDATE ON journal_lines
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_journal_entry_balanced();
+
+-- Seed the system ledger accounts
+INSERT INTO ledger_accounts (code, name, ledger_type) VALUES
+    ('BANK_CASH', 'Bank cash', 'asset'),
+    ('ATM_CASH_IN_TRANSIT', 'ATM cash in transit', 'asset'),
+    ('FEE_INCOME', 'Fee income', 'income'),
+    ('OPENING_BALANCE_EQUITY', 'Opening balance equity', 'equity');
+
+-- Accounts opened before the ledger existed already hold money. Post one
+-- opening entry per funded account against opening balance equity, so the
+-- balance derived from the journal matches accounts.balance from the start.
+DO $$
+DECLARE
+    account RECORD;
+    equity_id INTEGER;
+    ledger_id INTEGER;
+    entry_id INTEGER;
+BEGIN
+    SELECT id INTO equity_id FROM ledger_accounts WHERE code = 'OPENING_BALANCE_EQUITY';
+
+    FOR account IN SELECT id, balance FROM accounts WHERE balance <> 0 ORDER BY id LOOP
+        INSERT INTO ledger_accounts (code, name, ledger_type, account_id)
+        VALUES ('CUST-' || account.id, 'Customer account ' || account.id, 'liability', account.id)
+        RETURNING id INTO ledger_id;
+
+        INSERT INTO journal_entries (description)
+        VALUES ('opening balance of account ' || account.id)
+        RETURNING id INTO entry_id;
+
+        INSERT INTO journal_lines (entry_id, ledger_account_id, amount) VALUES
+            (entry_id, equity_id, account.balance),
+            (entry_id, ledger_id, -account.balance);
+    END LOOP;
+END;
+$$;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS transactions_receiver_account_id_date_idx;
+DROP INDEX IF EXISTS transactions_account_id_date_idx;
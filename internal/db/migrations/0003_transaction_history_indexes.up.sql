@@ -0,0 +1,3 @@
+-- Indexes for the transaction history, which is paginated on (transaction_date, id)
+CREATE INDEX transactions_account_id_date_idx ON transactions (account_id, transaction_date DESC, id DESC);
+CREATE INDEX transactions_receiver_account_id_date_idx ON transactions (receiver_account_id, transaction_date DESC, id DESC);
@@ -0,0 +1,20 @@
+-- TOTP enrollment of users. The secret is encrypted by the application and
+-- last_step is the time step of the last accepted code, so codes cannot be replayed.
+CREATE TABLE user_mfa (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    enabled_at TIMESTAMP NULL
+);
+
+-- Single-use recovery codes, stored as bcrypt hashes
+CREATE TABLE mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    code_hash VARCHAR(100) NOT NULL,
+    used_at TIMESTAMP NULL
+);
+
+CREATE INDEX mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id) WHERE used_at IS NULL;
@@ -0,0 +1,14 @@
+-- Append-only trail of security relevant events such as lockouts.
+-- user_id is who the event is about and actor_id who caused it, if known.
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    user_id UUID NULL REFERENCES users(id) ON DELETE SET NULL,
+    actor_id UUID NULL REFERENCES users(id) ON DELETE SET NULL,
+    ip VARCHAR(45) NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_events_user_id_idx ON audit_events (user_id, created_at DESC);
+CREATE INDEX audit_events_event_type_idx ON audit_events (event_type, created_at DESC);
@@ -0,0 +1,33 @@
+-- Emails and usernames are stored lower-cased and trimmed, the form the
+-- application looks them up in, and usernames are unique like emails.
+--
+-- Users whose emails or usernames only differ in case or spacing would
+-- collide once normalized, so the migration stops and names them. They
+-- must be merged or renamed by hand before running it again.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(format('%s (users %s)', value, ids), '; ')
+    INTO duplicates
+    FROM (
+        SELECT 'email ' || LOWER(TRIM(email)) AS value, string_agg(id::TEXT, ', ' ORDER BY created_at) AS ids
+        FROM users
+        GROUP BY LOWER(TRIM(email))
+        HAVING COUNT(*) > 1
+        UNION ALL
+        SELECT 'username ' || LOWER(TRIM(username)), string_agg(id::TEXT, ', ' ORDER BY created_at)
+        FROM users
+        GROUP BY LOWER(TRIM(username))
+        HAVING COUNT(*) > 1
+    ) d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'cannot normalize users, these emails or usernames are used by more than one user once lower-cased and trimmed: %', duplicates
+            USING HINT = 'Merge or rename the duplicate users, then run the migration again.';
+    END IF;
+END $$;
+
+UPDATE users SET email = LOWER(TRIM(email)), username = LOWER(TRIM(username));
+
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
@@ -0,0 +1,6 @@
+-- Contact details and date of birth of users, all optional
+ALTER TABLE users
+    ADD COLUMN phone VARCHAR(20) NULL,
+    ADD COLUMN address VARCHAR(255) NULL,
+    ADD COLUMN date_of_birth DATE NULL,
+    ADD COLUMN updated_at TIMESTAMP NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS kyc_documents;
+DROP TABLE IF EXISTS user_kyc;
@@ -0,0 +1,30 @@
+-- Identity verification (KYC) state of every user. Users registered before
+-- KYC existed were onboarded without it and are treated as approved.
+CREATE TABLE user_kyc (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'submitted', 'approved', 'rejected')),
+    submitted_at TIMESTAMP NULL,
+    reviewed_at TIMESTAMP NULL,
+    reviewer_id UUID NULL REFERENCES users(id) ON DELETE SET NULL,
+    rejection_reason VARCHAR(500) NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX user_kyc_status_idx ON user_kyc (status, submitted_at);
+
+INSERT INTO user_kyc (user_id, status, reviewed_at)
+SELECT id, 'approved', CURRENT_TIMESTAMP FROM users;
+
+-- Identity documents uploaded for KYC. The files are kept in the blob store
+-- under blob_key, at most one document of every type per user.
+CREATE TABLE kyc_documents (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    document_type VARCHAR(30) NOT NULL,
+    blob_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, document_type)
+);
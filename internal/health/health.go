@@ -0,0 +1,185 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/db"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusDegraded = "degraded"
+)
+
+// Check is a single dependency check
+type Check struct {
+	Name string
+	// Critical checks make the service not ready when they fail,
+	// other failures only mark it as degraded
+	Critical bool
+	Fn       func(ctx context.Context) error
+}
+
+// Result is the outcome of a single check. The error of a failed check is
+// logged rather than reported, since it can name hosts and ports and the
+// readiness route is public.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Report is the outcome of all checks
+type Report struct {
+	Status string    `json:"status"`
+	Checks []*Result `json:"checks"`
+}
+
+// Ready reports whether every critical check passed
+func (r *Report) Ready() bool {
+	return r.Status != StatusDown
+}
+
+// Checker runs dependency checks concurrently
+type Checker struct {
+	Timeout time.Duration
+	Checks  []Check
+}
+
+// NewChecker creates a new Checker where each check is bounded by timeout
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		Timeout: timeout,
+		Checks:  checks,
+	}
+}
+
+// Run runs every check and measures its latency
+func (c *Checker) Run(ctx context.Context) *Report {
+	report := &Report{
+		Status: StatusUp,
+		Checks: make([]*Result, len(c.Checks)),
+	}
+
+	wg := sync.WaitGroup{}
+	for i, check := range c.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Fn(ctx)
+			result := &Result{
+				Name:      check.Name,
+				Status:    StatusUp,
+				Critical:  check.Critical,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = StatusDown
+				logger.WithContext(ctx).Warnw("health check failed",
+					"check", check.Name,
+					"critical", check.Critical,
+					"error", err,
+				)
+			}
+			report.Checks[i] = result
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == StatusUp {
+			continue
+		}
+		if result.Critical {
+			report.Status = StatusDown
+			break
+		}
+		report.Status = StatusDegraded
+	}
+
+	return report
+}
+
+// Postgres checks that the database answers a ping
+func Postgres(pg *sqlx.DB) Check {
+	return Check{
+		Name:     "postgres",
+		Critical: true,
+		Fn: func(ctx context.Context) error {
+			return pg.PingContext(ctx)
+		},
+	}
+}
+
+// Redis checks that redis answers a ping
+func Redis(rdb *redis.Client) Check {
+	return Check{
+		Name:     "redis",
+		Critical: true,
+		Fn: func(ctx context.Context) error {
+			return rdb.Ping(ctx).Err()
+		},
+	}
+}
+
+// Migrations checks that the database schema is at least at the version this
+// binary expects. A newer schema is fine: during a rolling deploy the new
+// release migrates while the old pods keep serving.
+func Migrations(migrator *db.Migrator) Check {
+	return Check{
+		Name:     "migrations",
+		Critical: true,
+		Fn: func(ctx context.Context) error {
+			version, err := migrator.Version(ctx)
+			if err != nil {
+				return err
+			}
+
+			if version < migrator.LatestVersion() {
+				return fmt.Errorf("schema is at version %d, expected at least %d", version, migrator.LatestVersion())
+			}
+
+			return nil
+		},
+	}
+}
+
+// HTTP checks that url answers GET with 200 OK
+func HTTP(name string, url string, critical bool) Check {
+	return Check{
+		Name:     name,
+		Critical: critical,
+		Fn: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return err
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				return fmt.Errorf("unexpected status %d", res.StatusCode)
+			}
+
+			return nil
+		},
+	}
+}
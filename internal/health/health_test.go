@@ -0,0 +1,59 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.Logger = zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
+
+// check returns a check named name that fails with err
+func check(name string, critical bool, err error) Check {
+	return Check{
+		Name:     name,
+		Critical: critical,
+		Fn:       func(ctx context.Context) error { return err },
+	}
+}
+
+func TestRun(t *testing.T) {
+	dialErr := errors.New("dial tcp 10.0.0.5:5432: connect: connection refused")
+
+	tests := []struct {
+		name   string
+		checks []Check
+		status string
+	}{
+		{"all up", []Check{check("postgres", true, nil), check("redis", true, nil)}, StatusUp},
+		{"optional down", []Check{check("postgres", true, nil), check("atm", false, dialErr)}, StatusDegraded},
+		{"critical down", []Check{check("postgres", true, dialErr), check("atm", false, dialErr)}, StatusDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewChecker(time.Second, tt.checks...).Run(context.Background())
+			if report.Status != tt.status {
+				t.Errorf("status %s, want %s", report.Status, tt.status)
+			}
+
+			body, err := json.Marshal(report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(body), "10.0.0.5") {
+				t.Errorf("report leaks the check error: %s", body)
+			}
+		})
+	}
+}
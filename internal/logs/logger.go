@@ -1,15 +1,19 @@
 package logger
 
 import (
+	"context"
 	"log"
 
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 var Logger *zap.SugaredLogger
 
+type requestIDKey struct{}
+
 func InitLogger() {
-	logger, err := zap.NewProduction()
+	logger, err := zap.NewProduction(zap.WrapCore(NewRedactingCore))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -21,3 +25,38 @@ func CloseLogger() {
 		_ = Logger.Sync()
 	}
 }
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// WithContext returns the logger with the request ID and the trace and span
+// IDs of ctx attached, so log lines can be found from a trace and the other
+// way round
+func WithContext(ctx context.Context) *zap.SugaredLogger {
+	fields := []interface{}{}
+	if requestID := RequestID(ctx); requestID != "" {
+		fields = append(fields, "request_id", requestID)
+	}
+
+	spanContext := trace.SpanContextFromContext(ctx)
+	if spanContext.IsValid() {
+		fields = append(fields,
+			"trace_id", spanContext.TraceID().String(),
+			"span_id", spanContext.SpanID().String(),
+		)
+	}
+
+	if len(fields) == 0 {
+		return Logger
+	}
+
+	return Logger.With(fields...)
+}
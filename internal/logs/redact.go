@@ -0,0 +1,201 @@
+package logger
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redacted replaces values that must never reach the logs
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are field names whose values are always replaced,
+// compared after lower-casing and removing - and _
+var sensitiveKeys = map[string]bool{
+	"password":        true,
+	"newpassword":     true,
+	"oldpassword":     true,
+	"currentpassword": true,
+	"token":           true,
+	"accesstoken":     true,
+	"refreshtoken":    true,
+	"secret":          true,
+	"authorization":   true,
+	"cookie":          true,
+	"setcookie":       true,
+	"apikey":          true,
+	"mfacode":         true,
+	"mfatoken":        true,
+	"phone":           true,
+	"address":         true,
+	"dateofbirth":     true,
+}
+
+// accountKeys are field names whose values are account numbers, compared
+// like sensitiveKeys. Only their last four digits are logged.
+var accountKeys = map[string]bool{
+	"accountid":         true,
+	"receiveraccountid": true,
+	"fromaccountid":     true,
+	"toaccountid":       true,
+	"accountnumber":     true,
+}
+
+// safeKeys are fields added by this package that never hold personal data
+var safeKeys = map[string]bool{
+	"trace_id":   true,
+	"span_id":    true,
+	"request_id": true,
+}
+
+// secretKeys are the keys whose values are masked inside free text
+const secretKeys = `(?:password|new_password|old_password|current_password|token|access_token|refresh_token|secret|api_key|mfa_code|mfa_token)`
+
+// accountNumberKeys are the keys whose values are masked as account numbers
+// inside free text
+const accountNumberKeys = `(?:account_id|receiver_account_id|from_account_id|to_account_id|account_number)`
+
+var (
+	// jwtPattern matches the three base64url segments of a JWT
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// bearerPattern matches the credentials of an Authorization header
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+	// quotedSecretPattern matches "key":"value" pairs of sensitive keys
+	quotedSecretPattern = regexp.MustCompile(`(?i)("?` + secretKeys + `"?\s*[:=]\s*)"[^"]*"`)
+	// secretPairPattern matches unquoted key=value pairs of sensitive keys
+	secretPairPattern = regexp.MustCompile(`(?i)("?` + secretKeys + `"?\s*[:=]\s*)[^\s",&;}]+`)
+	// urlPasswordPattern matches the password in a URL such as a database DSN
+	urlPasswordPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]+:)[^@\s]+@`)
+	// emailPattern matches an email address, keeping its first letter and domain
+	emailPattern = regexp.MustCompile(`\b([A-Za-z0-9])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})\b`)
+	// accountPairPattern matches the numbers of key:value pairs of account keys.
+	// Numbers are only masked by key, other long numbers such as transaction
+	// references are left alone.
+	accountPairPattern = regexp.MustCompile(`(?i)("?` + accountNumberKeys + `"?\s*[:=]\s*"?)(\d+)`)
+)
+
+// Redact masks emails, tokens, passwords and account numbers in s. Account
+// numbers are recognised by their key.
+func Redact(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "$1 "+redacted)
+	s = urlPasswordPattern.ReplaceAllString(s, "${1}"+redacted+"@")
+	s = quotedSecretPattern.ReplaceAllString(s, `${1}"`+redacted+`"`)
+	s = secretPairPattern.ReplaceAllString(s, "${1}"+redacted)
+	s = emailPattern.ReplaceAllString(s, "$1***@$2")
+	s = accountPairPattern.ReplaceAllStringFunc(s, func(pair string) string {
+		match := accountPairPattern.FindStringSubmatch(pair)
+		return match[1] + maskAccountNumber(match[2])
+	})
+	return s
+}
+
+// maskAccountNumber keeps the last four digits of an account number
+func maskAccountNumber(number string) string {
+	if len(number) <= 4 {
+		return number
+	}
+
+	return "******" + number[len(number)-4:]
+}
+
+// normalizeKey lower-cases key and removes - and _ from it
+func normalizeKey(key string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(key))
+}
+
+// isSensitiveKey reports whether values of the field key are always secret
+func isSensitiveKey(key string) bool {
+	return sensitiveKeys[normalizeKey(key)]
+}
+
+// isAccountKey reports whether values of the field key are account numbers
+func isAccountKey(key string) bool {
+	return accountKeys[normalizeKey(key)]
+}
+
+// redactField returns field with any personal data or secret in it masked
+func redactField(field zapcore.Field) zapcore.Field {
+	if safeKeys[field.Key] {
+		return field
+	}
+
+	if isSensitiveKey(field.Key) {
+		return zap.String(field.Key, redacted)
+	}
+
+	if isAccountKey(field.Key) {
+		switch field.Type {
+		case zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type,
+			zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type:
+			return zap.String(field.Key, maskAccountNumber(strconv.FormatInt(field.Integer, 10)))
+		case zapcore.StringType:
+			return zap.String(field.Key, maskAccountNumber(field.String))
+		}
+	}
+
+	switch field.Type {
+	case zapcore.StringType:
+		field.String = Redact(field.String)
+	case zapcore.ErrorType:
+		if err, ok := field.Interface.(error); ok {
+			return zap.String(field.Key, Redact(err.Error()))
+		}
+	case zapcore.StringerType, zapcore.ReflectType:
+		// Encode the value as it would be logged and redact the result
+		data, err := json.Marshal(field.Interface)
+		if err != nil {
+			return zap.String(field.Key, redacted)
+		}
+		if cleaned := Redact(string(data)); cleaned != string(data) {
+			return zap.Reflect(field.Key, json.RawMessage(cleaned))
+		}
+	}
+
+	return field
+}
+
+// redactFields masks every field in fields
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	cleaned := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		cleaned[i] = redactField(field)
+	}
+
+	return cleaned
+}
+
+// redactingCore masks the message and fields of every entry before it is
+// written, so personal data is removed no matter which call site logged it
+type redactingCore struct {
+	zapcore.Core
+}
+
+// NewRedactingCore wraps core so that it redacts everything it writes
+func NewRedactingCore(core zapcore.Core) zapcore.Core {
+	return &redactingCore{Core: core}
+}
+
+// With implements zapcore.Core
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(redactFields(fields))}
+}
+
+// Check implements zapcore.Core
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+// Write implements zapcore.Core
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = Redact(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRedactAccountNumbers(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"json account id", `{"account_id":1234567890123}`, `{"account_id":******0123}`},
+		{"quoted receiver", `{"receiver_account_id":"98765432"}`, `{"receiver_account_id":"******5432"}`},
+		{"key value pair", `from_account_id=55554444 to_account_id=12`, `from_account_id=******4444 to_account_id=12`},
+		{"transaction reference", `{"transaction_reference":"20261018123456"}`, `{"transaction_reference":"20261018123456"}`},
+		{"other numbers", `request took 123456789012 ns`, `request took 123456789012 ns`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.in); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactFieldAccountKeys(t *testing.T) {
+	tests := []struct {
+		field zap.Field
+		want  string
+	}{
+		{zap.Int("account_id", 123456789), "******6789"},
+		{zap.String("receiverAccountId", "1111222233334444"), "******4444"},
+		{zap.Int("account_id", 42), "42"},
+	}
+
+	for _, tt := range tests {
+		got := redactField(tt.field)
+		if got.String != tt.want {
+			t.Errorf("redactField(%s) = %q, want %q", tt.field.Key, got.String, tt.want)
+		}
+	}
+
+	reference := redactField(zap.String("transaction_reference", "20261018123456"))
+	if reference.String != "20261018123456" {
+		t.Errorf("transaction reference was redacted to %q", reference.String)
+	}
+}
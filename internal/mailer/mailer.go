@@ -0,0 +1,121 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bukharney/bank-core/internal/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New creates the mailer of the configured driver
+func New(cfg config.Mail) (Mailer, error) {
+	switch cfg.Driver {
+	case config.MailDriverSMTP:
+		return NewSMTPMailer(cfg), nil
+	case config.MailDriverFile:
+		return NewFileMailer(cfg.From, cfg.Dir)
+	case config.MailDriverMemory:
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// VerificationEmail is the email that asks a user to verify their address
+func VerificationEmail(to string, link string, ttl time.Duration) *Message {
+	return &Message{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: "Welcome to Bank Core.\n\n" +
+			"Open the link below to verify your email address:\n\n" +
+			link + "\n\n" +
+			"The link expires in " + formatTTL(ttl) + ". If you did not create an account, ignore this email.\n",
+	}
+}
+
+// PasswordResetEmail is the email with the link to reset a forgotten password
+func PasswordResetEmail(to string, link string, ttl time.Duration) *Message {
+	return &Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password of your Bank Core account.\n\n" +
+			"Open the link below to choose a new password:\n\n" +
+			link + "\n\n" +
+			"The link can be used once and expires in " + formatTTL(ttl) + ". " +
+			"If you did not ask for this, ignore this email, your password has not changed.\n",
+	}
+}
+
+// EmailChangedEmail tells a user at their old address that their email changed
+func EmailChangedEmail(to string, newEmail string) *Message {
+	return &Message{
+		To:      to,
+		Subject: "Your email address was changed",
+		Body: "The email address of your Bank Core account was changed to " + newEmail + ".\n\n" +
+			"If you did not change it, contact us right away.\n",
+	}
+}
+
+// PasswordChangedEmail tells a user that their password changed
+func PasswordChangedEmail(to string) *Message {
+	return &Message{
+		To:      to,
+		Subject: "Your password was changed",
+		Body: "The password of your Bank Core account was changed and your other devices were logged out.\n\n" +
+			"If you did not change it, reset your password and contact us right away.\n",
+	}
+}
+
+// KYCApprovedEmail tells a user that their identity was verified
+func KYCApprovedEmail(to string) *Message {
+	return &Message{
+		To:      to,
+		Subject: "Your identity was verified",
+		Body:    "Your identity documents were approved. You can now open accounts and transfer money.\n",
+	}
+}
+
+// KYCRejectedEmail tells a user why their identity documents were rejected
+func KYCRejectedEmail(to string, reason string) *Message {
+	return &Message{
+		To:      to,
+		Subject: "Your identity could not be verified",
+		Body: "We could not verify your identity with the documents you sent:\n\n" +
+			reason + "\n\n" +
+			"Please upload new documents and submit them again.\n",
+	}
+}
+
+// formatTTL formats how long a link is valid for, e.g. "48 hours"
+func formatTTL(ttl time.Duration) string {
+	switch {
+	case ttl >= time.Hour && ttl%time.Hour == 0:
+		return plural(int(ttl/time.Hour), "hour")
+	case ttl >= time.Minute && ttl%time.Minute == 0:
+		return plural(int(ttl/time.Minute), "minute")
+	default:
+		return ttl.String()
+	}
+}
+
+// plural formats n of unit, e.g. "1 hour" or "2 hours"
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bukharney/bank-core/internal/money"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+const namespace = "bank"
+
+// Transaction outcomes used as the status label
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// Registry holds every metric exposed on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts requests by method, route pattern and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPDuration observes request latency by method, route pattern and status code
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// Transactions counts money movements by type and outcome
+	Transactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_total",
+		Help:      "Transfers, deposits and withdrawals by type and status.",
+	}, []string{"type", "status"})
+
+	// TransactionAmounts observes money movements in major currency units
+	TransactionAmounts = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transaction_amount",
+		Help:      "Amounts of transfers, deposits and withdrawals in major currency units.",
+		Buckets:   []float64{100, 500, 1_000, 5_000, 10_000, 50_000, 100_000, 500_000, 1_000_000},
+	}, []string{"type", "status"})
+
+	// ATMDispenseFailures counts withdrawals the ATM could not be told to dispense
+	ATMDispenseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "atm_dispense_failures_total",
+		Help:      "Withdrawals where the ATM did not confirm the dispense signal.",
+	}, []string{"atm_id"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		Transactions,
+		TransactionAmounts,
+		ATMDispenseFailures,
+	)
+}
+
+// RegisterPools exposes the connection pool statistics of postgres and redis
+func RegisterPools(pg *sqlx.DB, rdb *redis.Client) error {
+	err := Registry.Register(collectors.NewDBStatsCollector(pg.DB, "postgres"))
+	if err != nil {
+		return err
+	}
+
+	return Registry.Register(newRedisPoolCollector(rdb))
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveTransaction records the outcome of a transfer, deposit or withdrawal
+func ObserveTransaction(transactionType string, amount money.Money, err error) {
+	status := StatusSuccess
+	if err != nil {
+		status = StatusFailed
+	}
+
+	Transactions.WithLabelValues(transactionType, status).Inc()
+	TransactionAmounts.WithLabelValues(transactionType, status).Observe(float64(amount.Minor()) / 100)
+}
+
+// ObserveATMDispenseFailure records a failed dispense signal to an ATM
+func ObserveATMDispenseFailure(atmID int) {
+	ATMDispenseFailures.WithLabelValues(strconv.Itoa(atmID)).Inc()
+}
+
+// redisPoolCollector exports go-redis connection pool statistics
+type redisPoolCollector struct {
+	rdb        *redis.Client
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	totalConns *prometheus.Desc
+	idleConns  *prometheus.Desc
+	staleConns *prometheus.Desc
+}
+
+// newRedisPoolCollector creates a collector for the pool of rdb
+func newRedisPoolCollector(rdb *redis.Client) *redisPoolCollector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "redis_pool", name), help, nil, nil)
+	}
+
+	return &redisPoolCollector{
+		rdb:        rdb,
+		hits:       desc("hits_total", "Times a free connection was found in the pool."),
+		misses:     desc("misses_total", "Times a free connection was not found in the pool."),
+		timeouts:   desc("timeouts_total", "Times a wait for a connection timed out."),
+		totalConns: desc("connections", "Connections in the pool."),
+		idleConns:  desc("idle_connections", "Idle connections in the pool."),
+		staleConns: desc("stale_connections_total", "Stale connections removed from the pool."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+	ch <- c.totalConns
+	ch <- c.idleConns
+	ch <- c.staleConns
+}
+
+// Collect implements prometheus.Collector
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.rdb.PoolStats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.staleConns, prometheus.CounterValue, float64(stats.StaleConns))
+}
@@ -0,0 +1,385 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+)
+
+// Currency is an ISO 4217 currency code
+type Currency string
+
+// THB is the Thai baht
+const THB Currency = "THB"
+
+// DefaultCurrency is the currency used when an amount does not carry one
+const DefaultCurrency = THB
+
+// Scale is the number of decimal places stored for every amount.
+// It matches the DECIMAL(15, 2) columns in the database.
+const Scale = 2
+
+// unit is the number of minor units in one major unit
+const unit = 100
+
+var (
+	ErrInvalidAmount    = apperr.New(apperr.KindValidation, "invalid_amount", "invalid amount")
+	ErrSubMinorUnit     = apperr.New(apperr.KindValidation, "amount_too_precise", "amount has more than 2 decimal places")
+	ErrNotPositive      = apperr.New(apperr.KindValidation, "amount_not_positive", "amount must be greater than zero")
+	ErrCurrencyMismatch = apperr.New(apperr.KindValidation, "currency_mismatch", "currency mismatch")
+	ErrOverflow         = apperr.New(apperr.KindValidation, "amount_out_of_range", "amount out of range")
+)
+
+/*
+Money is a fixed-point amount stored as an integer number of minor units
+(satang for THB) together with its currency.
+
+Rounding rules:
+  - Parsing never rounds. Inputs with more than Scale significant decimal
+    places are rejected with ErrSubMinorUnit; trailing zeros are accepted.
+    Scan applies the same rule to floats, through their shortest decimal form.
+  - Arithmetic (Add, Sub) is exact and fails with ErrOverflow instead of wrapping.
+  - MulRat is the only operation that can produce a fractional minor unit;
+    it rounds half to even (banker's rounding).
+
+The zero value is zero in DefaultCurrency.
+*/
+type Money struct {
+	amount   int64
+	currency Currency
+}
+
+// New creates an amount from minor units in the given currency
+func New(minor int64, currency Currency) Money {
+	return Money{amount: minor, currency: currency}
+}
+
+// FromMinor creates an amount from minor units in the default currency
+func FromMinor(minor int64) Money {
+	return New(minor, DefaultCurrency)
+}
+
+// FromMajor creates an amount from whole major units in the default currency
+func FromMajor(major int64) (Money, error) {
+	if major > math.MaxInt64/unit || major < math.MinInt64/unit {
+		return Money{}, ErrOverflow
+	}
+
+	return FromMinor(major * unit), nil
+}
+
+// Parse parses a decimal string such as "12", "-3.5" or "1000.25"
+// into an amount in the default currency
+func Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Money{}, ErrInvalidAmount
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac, hasDot := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return Money{}, ErrInvalidAmount
+	}
+	if hasDot && frac == "" {
+		return Money{}, ErrInvalidAmount
+	}
+	if !isDigits(whole) || !isDigits(frac) {
+		return Money{}, ErrInvalidAmount
+	}
+
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > Scale {
+		return Money{}, ErrSubMinorUnit
+	}
+	frac += strings.Repeat("0", Scale-len(frac))
+
+	if whole == "" {
+		whole = "0"
+	}
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return Money{}, ErrOverflow
+	}
+	minor, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+
+	if major > (math.MaxInt64-minor)/unit {
+		return Money{}, ErrOverflow
+	}
+
+	amount := major*unit + minor
+	if negative {
+		amount = -amount
+	}
+
+	return FromMinor(amount), nil
+}
+
+// MustParse is like Parse but panics on error. It is intended for constants.
+func MustParse(s string) Money {
+	m, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// isDigits reports whether s contains only ASCII digits
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Minor returns the amount in minor units
+func (m Money) Minor() int64 {
+	return m.amount
+}
+
+// Major returns the whole major units, truncated towards zero
+func (m Money) Major() int64 {
+	return m.amount / unit
+}
+
+// Currency returns the currency of the amount
+func (m Money) Currency() Currency {
+	if m.currency == "" {
+		return DefaultCurrency
+	}
+
+	return m.currency
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.amount == 0
+}
+
+// IsPositive reports whether the amount is greater than zero
+func (m Money) IsPositive() bool {
+	return m.amount > 0
+}
+
+// IsNegative reports whether the amount is less than zero
+func (m Money) IsNegative() bool {
+	return m.amount < 0
+}
+
+// IsWhole reports whether the amount has no minor-unit part
+func (m Money) IsWhole() bool {
+	return m.amount%unit == 0
+}
+
+// ValidatePositive checks that the amount can be moved between accounts
+func (m Money) ValidatePositive() error {
+	if !m.IsPositive() {
+		return ErrNotPositive
+	}
+
+	return nil
+}
+
+// SameCurrency reports whether both amounts are in the same currency
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency() == o.Currency()
+}
+
+// Add returns m + o
+func (m Money) Add(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return Money{}, ErrCurrencyMismatch
+	}
+
+	sum := m.amount + o.amount
+	if (o.amount > 0 && sum < m.amount) || (o.amount < 0 && sum > m.amount) {
+		return Money{}, ErrOverflow
+	}
+
+	return New(sum, m.Currency()), nil
+}
+
+// Sub returns m - o
+func (m Money) Sub(o Money) (Money, error) {
+	if o.amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+
+	return m.Add(o.Neg())
+}
+
+// Neg returns -m
+func (m Money) Neg() Money {
+	return New(-m.amount, m.Currency())
+}
+
+// Cmp compares the amounts in minor units and returns -1, 0 or +1.
+// Callers must check SameCurrency first when currencies can differ.
+func (m Money) Cmp(o Money) int {
+	switch {
+	case m.amount < o.amount:
+		return -1
+	case m.amount > o.amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// MulRat returns m * num / den rounded half to even, e.g. for fees and interest
+func (m Money) MulRat(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, ErrInvalidAmount
+	}
+
+	r := new(big.Rat).SetFrac(
+		new(big.Int).Mul(big.NewInt(m.amount), big.NewInt(num)),
+		big.NewInt(den),
+	)
+
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	// Compare twice the remainder with the denominator to decide rounding
+	twice := new(big.Int).Abs(new(big.Int).Lsh(rem, 1))
+	switch twice.Cmp(r.Denom()) {
+	case 1:
+		q.Add(q, big.NewInt(int64(r.Sign())))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(int64(r.Sign())))
+		}
+	}
+
+	if !q.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+
+	return New(q.Int64(), m.Currency()), nil
+}
+
+// String formats the amount as a plain decimal with Scale places, e.g. "-12.05"
+func (m Money) String() string {
+	sign := ""
+	abs := uint64(m.amount)
+	if m.amount < 0 {
+		sign = "-"
+		abs = uint64(-m.amount)
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, abs/unit, abs%unit)
+}
+
+// moneyJSON is the object form of an amount, e.g.
+// {"amount": "12.30", "currency": "THB"}
+type moneyJSON struct {
+	Amount   string   `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+// MarshalJSON encodes the amount in its object form, with the amount as a
+// decimal string so clients never read it through a float
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.String(), Currency: m.Currency()})
+}
+
+// UnmarshalJSON accepts a JSON number (12.34), a decimal string ("12.34")
+// or an object such as {"amount": "12.34", "currency": "THB"}
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var (
+		raw      string
+		currency Currency
+	)
+
+	switch data[0] {
+	case '{':
+		v := moneyJSON{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		raw, currency = v.Amount, v.Currency
+	case '"':
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	default:
+		raw = string(data)
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	*m = New(parsed.amount, currency)
+	return nil
+}
+
+// Scan implements sql.Scanner for DECIMAL columns
+func (m *Money) Scan(src interface{}) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+		return nil
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	case int64:
+		parsed, err := FromMajor(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case float64:
+		// The shortest decimal that round-trips the float, so 0.3 scans as
+		// 0.30 while 0.30000000000000004 is rejected like any sub-cent input
+		raw = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		return fmt.Errorf("money: cannot scan %q: %w", raw, err)
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Value implements driver.Valuer, encoding the amount as an exact decimal string
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
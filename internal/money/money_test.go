@@ -0,0 +1,207 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		minor int64
+		err   error
+	}{
+		{"12", 1200, nil},
+		{"12.3", 1230, nil},
+		{"12.30", 1230, nil},
+		{"12.300", 1230, nil},
+		{".5", 50, nil},
+		{" 7.01 ", 701, nil},
+		{"+1.25", 125, nil},
+		{"-1.25", -125, nil},
+		{"-0.01", -1, nil},
+		{"0", 0, nil},
+		{"92233720368547758.07", math.MaxInt64, nil},
+		{"-92233720368547758.07", -math.MaxInt64, nil},
+
+		{"12.345", 0, ErrSubMinorUnit},
+		{"0.001", 0, ErrSubMinorUnit},
+		{"-0.005", 0, ErrSubMinorUnit},
+
+		{"92233720368547758.08", 0, ErrOverflow},
+		{"-92233720368547758.08", 0, ErrOverflow},
+		{"100000000000000000000", 0, ErrOverflow},
+
+		{"", 0, ErrInvalidAmount},
+		{"-", 0, ErrInvalidAmount},
+		{"+-1", 0, ErrInvalidAmount},
+		{"--1", 0, ErrInvalidAmount},
+		{".", 0, ErrInvalidAmount},
+		{"1.", 0, ErrInvalidAmount},
+		{"1.2.3", 0, ErrInvalidAmount},
+		{"1e3", 0, ErrInvalidAmount},
+		{"1,000", 0, ErrInvalidAmount},
+		{"NaN", 0, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		m, err := Parse(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q): got error %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && m.Minor() != tt.minor {
+			t.Errorf("Parse(%q) = %d minor units, want %d", tt.in, m.Minor(), tt.minor)
+		}
+		if err == nil && m.Currency() != DefaultCurrency {
+			t.Errorf("Parse(%q): currency %s, want %s", tt.in, m.Currency(), DefaultCurrency)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, m := range []Money{
+		FromMinor(1230),
+		FromMinor(-5),
+		FromMinor(0),
+		Money{},
+		New(math.MaxInt64, DefaultCurrency),
+		New(199, "USD"),
+	} {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("Marshal(%v): %v", m, err)
+		}
+
+		var got Money
+		err = json.Unmarshal(data, &got)
+		if err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+
+		if got.Minor() != m.Minor() || got.Currency() != m.Currency() {
+			t.Errorf("%s decoded as %v %s, want %v %s", data, got, got.Currency(), m, m.Currency())
+		}
+	}
+
+	data, err := json.Marshal(New(1230, "USD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"amount":"12.30","currency":"USD"}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in       string
+		minor    int64
+		currency Currency
+		err      error
+	}{
+		{`12.34`, 1234, DefaultCurrency, nil},
+		{`"12.34"`, 1234, DefaultCurrency, nil},
+		{`{"amount": "12.34", "currency": "USD"}`, 1234, "USD", nil},
+		{`{"amount": "12.34"}`, 1234, DefaultCurrency, nil},
+		{`12.345`, 0, "", ErrSubMinorUnit},
+		{`"abc"`, 0, "", ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		var m Money
+		err := json.Unmarshal([]byte(tt.in), &m)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Unmarshal(%s): got error %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && (m.Minor() != tt.minor || m.Currency() != tt.currency) {
+			t.Errorf("Unmarshal(%s) = %d %s, want %d %s", tt.in, m.Minor(), m.Currency(), tt.minor, tt.currency)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   interface{}
+		minor int64
+		err   error
+	}{
+		{"nil", nil, 0, nil},
+		{"string", "12.34", 1234, nil},
+		{"bytes", []byte("-0.50"), -50, nil},
+		{"int64", int64(12), 1200, nil},
+		{"float64", 0.3, 30, nil},
+		{"whole float64", float64(250), 25000, nil},
+		{"negative float64", -19.99, -1999, nil},
+
+		{"sub-cent string", "0.001", 0, ErrSubMinorUnit},
+		{"sub-cent float64", 0.30000000000000004, 0, ErrSubMinorUnit},
+		{"fraction of a cent float64", 0.125, 0, ErrSubMinorUnit},
+		{"int64 overflow", int64(math.MaxInt64), 0, ErrOverflow},
+		{"float64 overflow", 1e20, 0, ErrOverflow},
+		{"NaN", math.NaN(), 0, ErrInvalidAmount},
+		{"infinity", math.Inf(1), 0, ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		var m Money
+		err := m.Scan(tt.src)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && m.Minor() != tt.minor {
+			t.Errorf("%s: scanned %d minor units, want %d", tt.name, m.Minor(), tt.minor)
+		}
+	}
+
+	var m Money
+	if err := m.Scan(true); err == nil {
+		t.Error("scanning a bool succeeded")
+	}
+}
+
+func TestMulRat(t *testing.T) {
+	tests := []struct {
+		minor    int64
+		num, den int64
+		want     int64
+	}{
+		{1000, 1, 4, 250},
+		{1000, 3, 7, 429},
+		// Exact halves round to the even neighbour
+		{5, 1, 2, 2},
+		{7, 1, 2, 4},
+		{-5, 1, 2, -2},
+		{-7, 1, 2, -4},
+		{25, 1, 10, 2},
+		{35, 1, 10, 4},
+		// Anything past the half rounds away from zero
+		{251, 1, 100, 3},
+		{-251, 1, 100, -3},
+		{1, -1, 3, 0},
+		{2, -1, 3, -1},
+	}
+
+	for _, tt := range tests {
+		got, err := New(tt.minor, "USD").MulRat(tt.num, tt.den)
+		if err != nil {
+			t.Errorf("%d * %d/%d: %v", tt.minor, tt.num, tt.den, err)
+			continue
+		}
+		if got.Minor() != tt.want || got.Currency() != "USD" {
+			t.Errorf("%d * %d/%d = %d %s, want %d USD", tt.minor, tt.num, tt.den, got.Minor(), got.Currency(), tt.want)
+		}
+	}
+
+	if _, err := FromMinor(1).MulRat(1, 0); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("division by zero: got %v, want %v", err, ErrInvalidAmount)
+	}
+	if _, err := FromMinor(math.MaxInt64).MulRat(2, 1); !errors.Is(err, ErrOverflow) {
+		t.Errorf("overflow: got %v, want %v", err, ErrOverflow)
+	}
+}
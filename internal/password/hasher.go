@@ -0,0 +1,176 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bukharney/bank-core/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// argon2SaltSize and argon2KeySize are the sizes of an argon2id salt and hash in bytes
+	argon2SaltSize = 16
+	argon2KeySize  = 32
+	// argon2Prefix starts every argon2id hash in PHC string format
+	argon2Prefix = "$argon2id$"
+	// dummyPassword is hashed once to compare against when a user is unknown
+	dummyPassword = "not-a-real-password"
+)
+
+// ErrUnknownHash is returned for hashes of an unsupported algorithm
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// argon2Params are the cost parameters of an argon2id hash
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+/*
+Hasher hashes passwords with the configured algorithm, bcrypt or argon2id.
+
+It verifies hashes of either algorithm, so the algorithm or its cost can be
+changed at any time: Verify reports hashes made with other settings, and
+they are replaced with a new hash the next time the user logs in.
+*/
+type Hasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     argon2Params
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// NewHasher creates a Hasher with the configured algorithm and cost
+func NewHasher(cfg config.Password) *Hasher {
+	return &Hasher{
+		algorithm:  cfg.Hasher,
+		bcryptCost: cfg.BcryptCost,
+		argon2: argon2Params{
+			memory:      uint32(cfg.Argon2MemoryKiB),
+			iterations:  uint32(cfg.Argon2Iterations),
+			parallelism: uint8(cfg.Argon2Parallelism),
+		},
+	}
+}
+
+// Hash hashes password with the configured algorithm
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.algorithm == config.PasswordHasherArgon2id {
+		return h.hashArgon2(password)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// Verify reports whether password matches hash, and whether hash should be
+// replaced because it was made with another algorithm or cost
+func (h *Hasher) Verify(hash string, password string) (bool, bool, error) {
+	if strings.HasPrefix(hash, argon2Prefix) {
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, false, err
+		}
+
+		computed := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+
+		return true, h.algorithm != config.PasswordHasherArgon2id || params != h.argon2, nil
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, ErrUnknownHash
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+
+	return true, h.algorithm != config.PasswordHasherBcrypt || cost != h.bcryptCost, nil
+}
+
+// VerifyDummy does the work of verifying password against a hash of the
+// configured algorithm, so unknown users take as long to reject as known ones
+func (h *Hasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		h.dummyHash, _ = h.Hash(dummyPassword)
+	})
+
+	h.Verify(h.dummyHash, password)
+}
+
+// hashArgon2 hashes password with argon2id in PHC string format
+func (h *Hasher) hashArgon2(password string) (string, error) {
+	salt := make([]byte, argon2SaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	p := h.argon2
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, argon2KeySize)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// decodeArgon2 parses an argon2id hash in PHC string format
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	params := argon2Params{}
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism)
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	// argon2.IDKey panics on zero iterations or parallelism
+	if params.iterations < 1 || params.parallelism < 1 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,153 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bukharney/bank-core/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword is the password hashed by the tests
+const testPassword = "kq8vz3mxwr"
+
+// hasherConfig returns cheap settings for algorithm, so the tests run fast
+func hasherConfig(algorithm string) config.Password {
+	return config.Password{
+		Hasher:            algorithm,
+		BcryptCost:        bcrypt.MinCost,
+		Argon2MemoryKiB:   64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	}
+}
+
+func TestHasherRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{config.PasswordHasherBcrypt, config.PasswordHasherArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			h := NewHasher(hasherConfig(algorithm))
+
+			hash, err := h.Hash(testPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ok, rehash, err := h.Verify(hash, testPassword)
+			if err != nil || !ok || rehash {
+				t.Errorf("right password: ok %v, rehash %v, err %v", ok, rehash, err)
+			}
+
+			ok, _, err = h.Verify(hash, testPassword+"x")
+			if err != nil || ok {
+				t.Errorf("wrong password: ok %v, err %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRehash(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *config.Password)
+	}{
+		{"bcrypt to argon2id", func(cfg *config.Password) { cfg.Hasher = config.PasswordHasherArgon2id }},
+		{"bcrypt cost", func(cfg *config.Password) { cfg.BcryptCost++ }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRehash(t, hasherConfig(config.PasswordHasherBcrypt), tt.change)
+		})
+	}
+
+	argon2Tests := []struct {
+		name   string
+		change func(cfg *config.Password)
+	}{
+		{"argon2id to bcrypt", func(cfg *config.Password) { cfg.Hasher = config.PasswordHasherBcrypt }},
+		{"argon2id memory", func(cfg *config.Password) { cfg.Argon2MemoryKiB *= 2 }},
+		{"argon2id iterations", func(cfg *config.Password) { cfg.Argon2Iterations++ }},
+		{"argon2id parallelism", func(cfg *config.Password) { cfg.Argon2Parallelism++ }},
+	}
+	for _, tt := range argon2Tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRehash(t, hasherConfig(config.PasswordHasherArgon2id), tt.change)
+		})
+	}
+}
+
+// checkRehash hashes with cfg and verifies with cfg after change, which must
+// still accept the password but ask for a new hash
+func checkRehash(t *testing.T, cfg config.Password, change func(cfg *config.Password)) {
+	t.Helper()
+
+	hash, err := NewHasher(cfg).Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change(&cfg)
+	ok, rehash, err := NewHasher(cfg).Verify(hash, testPassword)
+	if err != nil || !ok {
+		t.Fatalf("old hash no longer verifies: ok %v, err %v", ok, err)
+	}
+	if !rehash {
+		t.Error("old hash not flagged for rehash")
+	}
+}
+
+func TestVerifyMalformedHash(t *testing.T) {
+	h := NewHasher(hasherConfig(config.PasswordHasherArgon2id))
+
+	valid, err := h.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+	salt, key := parts[4], parts[5]
+
+	hashes := map[string]string{
+		"empty":             "",
+		"plain text":        testPassword,
+		"unknown algorithm": "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key,
+		"missing key":       "$argon2id$v=19$m=64,t=1,p=1$" + salt,
+		"extra part":        valid + "$x",
+		"old version":       "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key,
+		"no version":        "$argon2id$$m=64,t=1,p=1$" + salt + "$" + key,
+		"zero parallelism":  "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key,
+		"zero iterations":   "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key,
+		"parallelism > 255": "$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key,
+		"negative memory":   "$argon2id$v=19$m=-1,t=1,p=1$" + salt + "$" + key,
+		"missing params":    "$argon2id$v=19$m=64$" + salt + "$" + key,
+		"empty salt":        "$argon2id$v=19$m=64,t=1,p=1$$" + key,
+		"empty key":         "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$",
+		"salt not base64":   "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key,
+		"key not base64":    "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!",
+		"truncated bcrypt":  "$2a$10$abc",
+		"bcrypt bad cost":   "$2a$99$" + strings.Repeat("a", 53),
+	}
+
+	for name, hash := range hashes {
+		t.Run(name, func(t *testing.T) {
+			ok, rehash, err := h.Verify(hash, testPassword)
+			if err == nil || ok || rehash {
+				t.Errorf("got ok %v, rehash %v, err %v, want an error", ok, rehash, err)
+			}
+		})
+	}
+
+	if _, _, err := h.Verify("$argon2id$v=19$m=64,t=1,p=0$"+salt+"$"+key, testPassword); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("zero parallelism: got %v, want %v", err, ErrUnknownHash)
+	}
+}
+
+func TestVerifyDummy(t *testing.T) {
+	for _, algorithm := range []string{config.PasswordHasherBcrypt, config.PasswordHasherArgon2id} {
+		h := NewHasher(hasherConfig(algorithm))
+		h.VerifyDummy(testPassword)
+
+		if ok, _, err := h.Verify(h.dummyHash, dummyPassword); err != nil || !ok {
+			t.Errorf("%s: dummy hash does not verify: %v, %v", algorithm, ok, err)
+		}
+	}
+}
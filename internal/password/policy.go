@@ -0,0 +1,104 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bukharney/bank-core/internal/config"
+)
+
+// breachedList is the built-in list of common and breached passwords
+//
+//go:embed breached.txt
+var breachedList []byte
+
+// minPersonalLength is the shortest personal detail, such as a username,
+// that a password may not contain
+const minPersonalLength = 4
+
+// Reasons a password is refused
+var (
+	ErrTooShort     = errors.New("password is too short")
+	ErrTooLong      = errors.New("password is too long")
+	ErrBreached     = errors.New("password is too common or has appeared in a data breach")
+	ErrPersonalInfo = errors.New("password must not contain your username or email")
+)
+
+// Policy decides which passwords users may choose
+type Policy struct {
+	minLength int
+	maxLength int
+	breached  map[string]bool
+}
+
+// NewPolicy creates the configured Policy, loading the breached password
+// list from cfg.BreachedList in addition to the built-in one
+func NewPolicy(cfg config.Password) (*Policy, error) {
+	p := &Policy{
+		minLength: cfg.MinLength,
+		maxLength: cfg.MaxLength,
+		breached:  map[string]bool{},
+	}
+
+	p.addBreached(breachedList)
+
+	if cfg.BreachedList != "" {
+		data, err := os.ReadFile(cfg.BreachedList)
+		if err != nil {
+			return nil, fmt.Errorf("password.breached_list: %w", err)
+		}
+		p.addBreached(data)
+	}
+
+	return p, nil
+}
+
+// addBreached adds the passwords of a list with one password per line,
+// skipping blank lines and # comments
+func (p *Policy) addBreached(data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = true
+	}
+}
+
+/*
+Check returns why password is refused, or nil if it is allowed.
+
+The minimum length counts characters, the maximum counts bytes since bcrypt
+ignores anything past 72 bytes. personal holds details of the user, such as
+their username and email, that the password may not contain.
+*/
+func (p *Policy) Check(password string, personal ...string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return fmt.Errorf("%w, use at least %d characters", ErrTooShort, p.minLength)
+	}
+	if len(password) > p.maxLength {
+		return fmt.Errorf("%w, use at most %d bytes", ErrTooLong, p.maxLength)
+	}
+
+	lower := strings.ToLower(password)
+	if p.breached[lower] {
+		return ErrBreached
+	}
+
+	for _, detail := range personal {
+		// A password containing an email contains its local part too
+		detail, _, _ = strings.Cut(strings.ToLower(detail), "@")
+		if len(detail) >= minPersonalLength && strings.Contains(lower, detail) {
+			return ErrPersonalInfo
+		}
+	}
+
+	return nil
+}
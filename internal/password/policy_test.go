@@ -0,0 +1,118 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bukharney/bank-core/internal/config"
+)
+
+// newTestPolicy returns the default policy
+func newTestPolicy(t *testing.T) *Policy {
+	t.Helper()
+
+	p, err := NewPolicy(config.NewConfig().Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestCheckLength(t *testing.T) {
+	p := newTestPolicy(t)
+
+	tests := []struct {
+		name     string
+		password string
+		err      error
+	}{
+		{"minimum length", "kq8vz3mxwr", nil},
+		{"one short", "kq8vz3mxw", ErrTooShort},
+		// 10 characters but 20 bytes, the minimum counts characters
+		{"multi-byte at minimum", strings.Repeat("ก", 10), nil},
+		{"multi-byte one short", strings.Repeat("ก", 9), ErrTooShort},
+		{"maximum length", strings.Repeat("kq8vz3mx", 9), nil},
+		{"one byte too long", strings.Repeat("kq8vz3mx", 9) + "w", ErrTooLong},
+		// 30 characters but 90 bytes, the maximum counts bytes
+		{"multi-byte too long", strings.Repeat("ก", 30), ErrTooLong},
+	}
+
+	for _, tt := range tests {
+		if err := p.Check(tt.password); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestCheckBreached(t *testing.T) {
+	p := newTestPolicy(t)
+
+	for _, password := range []string{"password123", "PassWord123", "1234567890"} {
+		if err := p.Check(password); !errors.Is(err, ErrBreached) {
+			t.Errorf("%q: got %v, want %v", password, err, ErrBreached)
+		}
+	}
+
+	// Only whole passwords are matched
+	if err := p.Check("password123 kq8vz3"); err != nil {
+		t.Errorf("password containing a breached one: %v", err)
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(list, []byte("# comment\n\n  Kq8vz3mxwr  \n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig().Password
+	cfg.BreachedList = list
+	p, err := NewPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Check("kq8vz3mxwr"); !errors.Is(err, ErrBreached) {
+		t.Errorf("password from the list: got %v, want %v", err, ErrBreached)
+	}
+	if err := p.Check("# comment"); errors.Is(err, ErrBreached) {
+		t.Error("comment line is in the list")
+	}
+	if err := p.Check("password123"); !errors.Is(err, ErrBreached) {
+		t.Errorf("built-in list dropped: got %v", err)
+	}
+
+	cfg.BreachedList = filepath.Join(t.TempDir(), "missing.txt")
+	if _, err := NewPolicy(cfg); err == nil {
+		t.Error("missing list accepted")
+	}
+}
+
+func TestCheckPersonalInfo(t *testing.T) {
+	p := newTestPolicy(t)
+
+	tests := []struct {
+		name     string
+		password string
+		err      error
+	}{
+		{"username", "xx-Somchai-2024", ErrPersonalInfo},
+		{"email local part", "my SOMCHAI.K pass", ErrPersonalInfo},
+		{"whole email", "somchai.k@example.com!", ErrPersonalInfo},
+		{"email domain only", "example.com rocks", nil},
+		{"short detail", "abc-kq8vz3mxwr", nil},
+		{"unrelated", "kq8vz3mxwr", nil},
+	}
+
+	for _, tt := range tests {
+		err := p.Check(tt.password, "somchai", "somchai.k@example.com", "abc")
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -1,80 +1,141 @@
 package responses
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bukharney/bank-core/internal/apperr"
+	logger "github.com/bukharney/bank-core/internal/logs"
+	"github.com/go-playground/validator/v10"
 )
 
-// JSON is a helper function to return a JSON response
-func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(data)
-}
+// ProblemContentType is the media type of error responses (RFC 7807)
+const ProblemContentType = "application/problem+json"
 
-// Error sends an error response
-func Error(w http.ResponseWriter, statusCode int, err error) {
-	JSON(w, statusCode, struct {
-		Error string `json:"error"`
-	}{
-		Error: err.Error(),
-	})
+/*
+Problem is an RFC 7807 problem details body.
+
+Type is always about:blank, so Title is the HTTP status text. Clients should
+branch on Code, which is stable across releases, not on Detail.
+*/
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      string            `json:"code"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
 }
 
-// Message sends a message response
-func Message(w http.ResponseWriter, statusCode int, message string) {
-	JSON(w, statusCode, struct {
-		Message string `json:"message"`
-	}{
-		Message: message,
-	})
+// classify turns err into an *apperr.Error. Errors that are neither typed
+// nor well known get the fallback kind; internal errors hide their message.
+func classify(err error, fallback apperr.Kind) *apperr.Error {
+	if e, ok := apperr.As(err); ok {
+		return e
+	}
+
+	var validationErrors validator.ValidationErrors
+	switch {
+	case err == nil:
+		return apperr.New(fallback, "", http.StatusText(apperr.Status(fallback)))
+	case errors.As(err, &validationErrors):
+		fields := make(map[string]string, len(validationErrors))
+		for _, fieldErr := range validationErrors {
+			fields[fieldName(fieldErr)] = "failed on the " + fieldErr.Tag() + " rule"
+		}
+		e := apperr.Validation("request validation failed", fields)
+		e.Err = err
+		return e
+	case errors.Is(err, sql.ErrNoRows):
+		return apperr.Wrap(err, apperr.KindNotFound, "", "resource not found")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return apperr.Wrap(err, apperr.KindTimeout, "", "request timed out")
+	case fallback == apperr.KindInternal:
+		return apperr.Internal(err)
+	default:
+		return apperr.Wrap(err, fallback, "", err.Error())
+	}
 }
 
-// Success sends a success response
-func Success(w http.ResponseWriter, data interface{}) {
-	JSON(w, http.StatusOK, data)
+// fieldName returns the JSON name of a validated field, e.g. "account_id"
+func fieldName(fieldErr validator.FieldError) string {
+	namespace := fieldErr.Namespace()
+	if _, rest, ok := strings.Cut(namespace, "."); ok {
+		return rest
+	}
+
+	return fieldErr.Field()
 }
 
-// Created sends a created response
-func Created(w http.ResponseWriter, data interface{}) {
-	JSON(w, http.StatusCreated, data)
+// writeProblem writes err as a problem+json response with the status of its kind
+func writeProblem(w http.ResponseWriter, r *http.Request, e *apperr.Error) {
+	status := e.Status()
+	if status >= http.StatusInternalServerError {
+		logger.WithContext(r.Context()).Errorw("request failed", "code", e.Code, "error", e.Error())
+	}
+
+	if e.RetryAfter > 0 {
+		// Round up so clients never retry too early
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(status)
+	encode(w, Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    e.Detail,
+		Instance:  r.URL.Path,
+		Code:      e.Code,
+		RequestID: logger.RequestID(r.Context()),
+		Errors:    e.Fields,
+	})
 }
 
-// NoContent sends a no content response
-func NoContent(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusNoContent)
+// Error sends err as a problem+json response. Typed errors keep their kind
+// and code, anything else is an internal error whose message is not shown.
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, classify(err, apperr.KindInternal))
 }
 
-func InternalServerError(w http.ResponseWriter, err error) {
-	Error(w, http.StatusInternalServerError, err)
+func InternalServerError(w http.ResponseWriter, r *http.Request, err error) {
+	Error(w, r, err)
 }
 
-// BadRequest sends a bad request response
-func BadRequest(w http.ResponseWriter, err error) {
-	Error(w, http.StatusBadRequest, err)
+// BadRequest sends err as a bad request unless it already has a kind
+func BadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, classify(err, apperr.KindBadRequest))
 }
 
-// Unauthorized sends an unauthorized response
-func Unauthorized(w http.ResponseWriter, err error) {
-	Error(w, http.StatusUnauthorized, err)
+// Unauthorized sends err as unauthorized unless it already has a kind
+func Unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, classify(err, apperr.KindUnauthorized))
 }
 
-// Forbidden sends a forbidden response
-func Forbidden(w http.ResponseWriter, err error) {
-	Error(w, http.StatusForbidden, err)
+// Forbidden sends err as forbidden unless it already has a kind
+func Forbidden(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, classify(err, apperr.KindForbidden))
 }
 
-// NotFound sends a not found response
-func NotFound(w http.ResponseWriter, err error) {
-	Error(w, http.StatusNotFound, err)
+// NotFound sends err as not found unless it already has a kind
+func NotFound(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, classify(err, apperr.KindNotFound))
 }
 
-// Conflict sends a conflict response
-func Conflict(w http.ResponseWriter, err error) {
-	Error(w, http.StatusConflict, err)
+// Conflict sends err as a conflict unless it already has a kind
+func Conflict(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, classify(err, apperr.KindConflict))
 }
 
 // Timeout sends a timeout response
-func Timeout(w http.ResponseWriter, err error) {
-	Error(w, http.StatusRequestTimeout, err)
+func Timeout(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, classify(err, apperr.KindTimeout))
 }
@@ -1 +1,42 @@
 package responses
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JSON is a helper function to return a JSON response
+func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encode(w, data)
+}
+
+// encode writes data as JSON
+func encode(w http.ResponseWriter, data interface{}) {
+	json.NewEncoder(w).Encode(data)
+}
+
+// Message sends a message response
+func Message(w http.ResponseWriter, statusCode int, message string) {
+	JSON(w, statusCode, struct {
+		Message string `json:"message"`
+	}{
+		Message: message,
+	})
+}
+
+// Success sends a success response
+func Success(w http.ResponseWriter, data interface{}) {
+	JSON(w, http.StatusOK, data)
+}
+
+// Created sends a created response
+func Created(w http.ResponseWriter, data interface{}) {
+	JSON(w, http.StatusCreated, data)
+}
+
+// NoContent sends a no content response
+func NoContent(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNoContent)
+}
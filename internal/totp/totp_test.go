@@ -0,0 +1,135 @@
+package totp
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 vectors of RFC 6238 appendix B. The RFC
+// lists 8 digit codes, ours are their last Digits digits.
+func TestCodeRFC6238(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		want := v.code[len(v.code)-Digits:]
+
+		got, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("T=%d: got %s, want %s", v.unix, got, want)
+		}
+
+		// Authenticator apps may show the secret in lower case
+		got, err = Code(strings.ToLower(rfcSecret), Step(time.Unix(v.unix, 0)))
+		if err != nil || got != want {
+			t.Errorf("T=%d lower case secret: got %s, %v, want %s", v.unix, got, err, want)
+		}
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	for offset := int64(-Skew - 2); offset <= Skew+2; offset++ {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := Validate(rfcSecret, code, now)
+		inWindow := offset >= -Skew && offset <= Skew
+		if ok != inWindow {
+			t.Errorf("code %d steps away: accepted %v, want %v", offset, ok, inWindow)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code %d steps away matched step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	if _, ok := Validate(rfcSecret, "287 082", now); !ok {
+		t.Error("code with a space rejected")
+	}
+
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+	if _, err := Code(a, 1); err != nil {
+		t.Errorf("generated secret is not usable: %v", err)
+	}
+}
+
+func TestSealer(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, keySize))
+	sealer, err := NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := sealer.Seal(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, rfcSecret) {
+		t.Error("sealed secret contains the secret")
+	}
+
+	opened, err := sealer.Open(sealed)
+	if err != nil || opened != rfcSecret {
+		t.Errorf("Open = %q, %v, want %q", opened, err, rfcSecret)
+	}
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)-2] ^= 1
+	if _, err := sealer.Open(string(tampered)); err == nil {
+		t.Error("tampered secret opened")
+	}
+
+	if _, err := NewSealer(base64.StdEncoding.EncodeToString(make([]byte, 16))); err == nil {
+		t.Error("short key accepted")
+	}
+}
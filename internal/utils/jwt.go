@@ -1,80 +1,188 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/apperr"
 	"github.com/bukharney/bank-core/internal/config"
+	"github.com/bukharney/bank-core/internal/keys"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// TokenClaims are the claims carried by access and refresh tokens
+type TokenClaims struct {
+	UserID string
+	// SessionID identifies the login session, and the refresh token family, the token belongs to
+	SessionID string
+	// ID is the unique ID (jti) of the token
+	ID   string
+	Role string
+	// Permissions are the permissions of the role when the token was issued
+	Permissions []string
+	ExpiresAt   time.Time
+}
+
+// NewTokenClaims creates the claims of a new refresh (t is true) or access (t is false) token of a session
+func NewTokenClaims(cfg *config.Config, userId string, sessionId string, role string, t bool) *TokenClaims {
+	return &TokenClaims{
+		UserID:      userId,
+		SessionID:   sessionId,
+		ID:          uuid.NewString(),
+		Role:        role,
+		Permissions: models.PermissionsOf(role),
+		ExpiresAt:   time.Now().Add(cfg.JWT.TTL(t)),
+	}
+}
+
+// Token types, so a refresh token cannot be used as an access token and the other way round
+const (
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+)
+
+// errMalformedAuthorization rejects Authorization headers that are not bearer tokens
+var errMalformedAuthorization = apperr.Unauthorized("malformed_authorization", "authorization header must be a bearer token")
+
+// keySet holds the keys tokens are signed and verified with
+var keySet *keys.KeySet
+
+// SetKeySet sets the keys tokens are signed and verified with
+func SetKeySet(set *keys.KeySet) {
+	keySet = set
+}
+
+// jwtClaims is the JSON encoding of TokenClaims
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	SessionID   string   `json:"sid,omitempty"`
+	TokenType   string   `json:"token_type"`
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// tokenType returns the type of a refresh (t is true) or access (t is false) token
+func tokenType(t bool) string {
+	if t {
+		return tokenTypeRefresh
+	}
+
+	return tokenTypeAccess
+}
+
 /*
-GenerateToken generates a JWT token
+GenerateToken generates a JWT token signed with the current signing key
 
-t is a boolean that determines the secret to use
-If t is true, the function will use the refresh token secret
-If t is false, the function will use the access token secret
+t is a boolean that determines the type of the token
+If t is true, the function will generate a refresh token
+If t is false, the function will generate an access token
 */
-func GenerateToken(cfg *config.Config, userId uuid.UUID, t bool) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId": userId,
-		"exp":    time.Now().Add(time.Hour * 24).Unix(),
+func GenerateToken(cfg *config.Config, claims *TokenClaims, t bool) (string, error) {
+	if keySet == nil {
+		return "", errors.New("jwt signing keys are not loaded")
+	}
+
+	key := keySet.SigningKey()
+	token := jwt.NewWithClaims(key.Method, jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    cfg.JWT.Issuer,
+			Audience:  jwt.ClaimStrings{cfg.JWT.Audience},
+			Subject:   claims.UserID,
+			ID:        claims.ID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(claims.ExpiresAt),
+		},
+		SessionID:   claims.SessionID,
+		TokenType:   tokenType(t),
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
 	})
+	token.Header["kid"] = key.ID
 
-	return token.SignedString([]byte(cfg.JWTSecret[t]))
+	return token.SignedString(key.Private)
 }
 
-// ParseToken parses a JWT token
-func ParseToken(cfg *config.Config, tokenString string, t bool) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.JWTSecret[t]), nil
-	})
+/*
+ParseTokenClaims parses a JWT token and returns its claims
+
+The signature is checked with the key named by the kid header, and the
+issuer, audience, subject, issue time, expiry, ID and token type are required.
+*/
+func ParseTokenClaims(cfg *config.Config, tokenString string, t bool) (*TokenClaims, error) {
+	if keySet == nil {
+		return nil, errors.New("jwt verification keys are not loaded")
+	}
+
+	claims := &jwtClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keySet.Keyfunc,
+		jwt.WithValidMethods(keySet.Methods()),
+		jwt.WithIssuer(cfg.JWT.Issuer),
+		jwt.WithAudience(cfg.JWT.Audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", err
+	if claims.Subject == "" || claims.ID == "" || claims.IssuedAt == nil || claims.TokenType != tokenType(t) {
+		return nil, jwt.ErrTokenInvalidClaims
 	}
 
-	return claims["userId"].(string), nil
+	return &TokenClaims{
+		UserID:      claims.Subject,
+		SessionID:   claims.SessionID,
+		ID:          claims.ID,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
+		ExpiresAt:   claims.ExpiresAt.Time,
+	}, nil
 }
 
-// ValidateToken validates a JWT token
-func ValidateToken(cfg *config.Config, tokenString string, t bool) bool {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.JWTSecret[t]), nil
-	})
+// ParseToken parses a JWT token and returns its userId
+func ParseToken(cfg *config.Config, tokenString string, t bool) (string, error) {
+	claims, err := ParseTokenClaims(cfg, tokenString, t)
 	if err != nil {
-		return false
+		return "", err
 	}
 
-	_, ok := token.Claims.(jwt.MapClaims)
-	return ok && token.Valid
+	return claims.UserID, nil
+}
+
+// ValidateToken validates a JWT token
+func ValidateToken(cfg *config.Config, tokenString string, t bool) bool {
+	_, err := ParseTokenClaims(cfg, tokenString, t)
+	return err == nil
 }
 
 // GetUserIdFromToken gets the userId from a JWT token
 func GetUserIdFromToken(cfg *config.Config, tokenString string, t bool) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.JWTSecret[t]), nil
-	})
-	if err != nil {
-		return "", err
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", err
-	}
-
-	return claims["userId"].(string), nil
+	return ParseToken(cfg, tokenString, t)
 }
 
-// ExtractToken extracts the token from the Cookie header
+/*
+ExtractToken extracts a token from the request
+
+API clients send the token as "Authorization: Bearer <token>", the access
+token on most routes and the refresh token on the refresh and logout routes.
+Browsers send it in the cookie called name.
+*/
 func ExtractToken(r *http.Request, name string) (string, error) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, token, ok := strings.Cut(authorization, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			return "", errMalformedAuthorization
+		}
+
+		return strings.TrimSpace(token), nil
+	}
+
 	cookie, err := r.Cookie(name)
 	if err != nil {
 		return "", err
@@ -93,32 +201,68 @@ func GetUserIdFromRequest(cfg *config.Config, r *http.Request, t bool) (string,
 	return GetUserIdFromToken(cfg, token, t)
 }
 
+// HasPermission reports whether the token grants permission
+func (c *TokenClaims) HasPermission(permission models.Permission) bool {
+	for _, granted := range c.Permissions {
+		if granted == string(permission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetTokenClaimsFromRequest gets the claims of the access token of the request
+func GetTokenClaimsFromRequest(cfg *config.Config, r *http.Request) (*TokenClaims, error) {
+	token, err := ExtractToken(r, "access_token")
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTokenClaims(cfg, token, false)
+}
+
 // GetExpirationFromToken gets the expiration time from a JWT token
 func GetExpirationFromToken(cfg *config.Config, tokenString string, t bool) (int64, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.JWTSecret[t]), nil
-	})
+	claims, err := ParseTokenClaims(cfg, tokenString, t)
 	if err != nil {
 		return 0, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return 0, err
+	return claims.ExpiresAt.Unix(), nil
+}
+
+// Names of the double-submit CSRF cookie and the header it must be echoed in
+const (
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// NewCSRFToken generates a random CSRF token
+func NewCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
 	}
 
-	return int64(claims["exp"].(float64)), nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-// SetToken sets the JWT token in a cookie
-func SetToken(w http.ResponseWriter, token *models.LoginResponse, time time.Time) {
+/*
+SetToken sets the JWT tokens and the CSRF token in cookies
 
+The CSRF cookie is readable by scripts, so the browser app can copy it into
+the X-CSRF-Token header of its requests, which other sites cannot do.
+*/
+func SetToken(w http.ResponseWriter, token *models.LoginResponse, time time.Time) {
 	http.SetCookie(w, &http.Cookie{
 		Path:     "/",
 		Name:     "access_token",
 		Value:    token.AccessToken,
 		Expires:  time,
 		HttpOnly: true,
+		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
 	})
 	http.SetCookie(w, &http.Cookie{
@@ -130,4 +274,12 @@ func SetToken(w http.ResponseWriter, token *models.LoginResponse, time time.Time
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
 	})
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     CSRFCookieName,
+		Value:    token.CSRFToken,
+		Expires:  time,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
 }
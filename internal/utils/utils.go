@@ -3,12 +3,17 @@ package utils
 import (
 	"context"
 	"encoding/json"
-	"errors"
+	"net"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/bukharney/bank-core/internal/api/models"
+	"github.com/bukharney/bank-core/internal/apperr"
 	"github.com/bukharney/bank-core/internal/responses"
+	"github.com/go-playground/validator/v10"
 )
 
 // UseTimeout uses a timeout for the request
@@ -25,7 +30,7 @@ func UseTimeout(ctx context.Context, timeout time.Duration, w http.ResponseWrite
 
 	select {
 	case <-ctx.Done():
-		responses.Timeout(w, ctx.Err())
+		responses.Timeout(w, r, ctx.Err())
 	case <-done:
 	}
 }
@@ -44,13 +49,43 @@ func TransactionReference() string {
 func GetIDFromRequest(r *http.Request, key string) (string, error) {
 	id := r.PathValue(key)
 	if id == "" {
-		return "", errors.New("missing id")
+		return "", apperr.BadRequest("missing_id", "missing "+key)
 	}
 
 	return id, nil
 }
 
+// GetClientInfo gets the user agent and IP address of the client making the request
+func GetClientInfo(r *http.Request) *models.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return &models.ClientInfo{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
+
 // StringToInt converts a string to an int
 func StringToInt(s string) (int, error) {
 	return strconv.Atoi(s)
 }
+
+// NewValidator creates a validator that reports fields by their JSON names
+func NewValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return models.IsUsername(fl.Field().String())
+	})
+
+	return validate
+}